@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snippet mirrors the JSON representation returned by the server
+type snippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"url"`
+}
+
+// apiError is returned for any response outside the 2xx range
+type apiError struct {
+	Status int
+	Msg    string            `json:"error"`
+	Fields map[string]string `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	if len(e.Fields) > 0 {
+		keys := make([]string, 0, len(e.Fields))
+		for key := range e.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		msgs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", key, e.Fields[key]))
+		}
+		return strings.Join(msgs, "; ")
+	}
+	if e.Msg != "" {
+		return e.Msg
+	}
+	return http.StatusText(e.Status)
+}
+
+type client struct {
+	cfg  *config
+	http *http.Client
+}
+
+func newClient(cfg *config) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &client{
+		cfg:  cfg,
+		http: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+	}
+}
+
+// do sends a request to the API and decodes the JSON response into dst, if dst isn't nil
+func (c *client) do(method, path string, body any, dst any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.cfg.Server, "/")+path, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &apiError{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(e)
+		return e
+	}
+
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func (c *client) create(title, content string, expires int, allowSecrets bool) (*snippet, error) {
+	var out struct {
+		Snippet *snippet `json:"snippet"`
+	}
+	body := map[string]any{"title": title, "content": content, "expires": expires, "allow_secrets": allowSecrets}
+	err := c.do(http.MethodPost, "/api/snippets", body, &out)
+	return out.Snippet, err
+}
+
+func (c *client) get(id int) (*snippet, error) {
+	var out struct {
+		Snippet *snippet `json:"snippet"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/snippets/%d", id), nil, &out)
+	return out.Snippet, err
+}
+
+func (c *client) list(mine bool) ([]*snippet, error) {
+	var out struct {
+		Snippets []*snippet `json:"snippets"`
+	}
+	path := "/api/snippets"
+	if mine {
+		path += "?mine=true"
+	}
+	err := c.do(http.MethodGet, path, nil, &out)
+	return out.Snippets, err
+}
+
+func (c *client) delete(id int) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/snippets/%d", id), nil, nil)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// config is stored as JSON in the user's config directory, e.g. ~/.config/snippetbox/config.json
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+	// Insecure skips TLS certificate verification, for the self-signed certificate used in development
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "snippetbox.json"
+	}
+	return filepath.Join(dir, "snippetbox", "config.json")
+}
+
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no config found, run 'snippet config -server URL -token TOKEN' first")
+		}
+		return nil, err
+	}
+
+	cfg := &config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// save writes the config readable only by the current user, since it contains the token
+func (cfg *config) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0600)
+}
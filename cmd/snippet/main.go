@@ -0,0 +1,159 @@
+// Command snippet is a command-line client for the Snippetbox API.
+//
+//	snippet config -server https://localhost:4000 -token sbx_...
+//	snippet create -title "Hello" -expires 7 < hello.txt
+//	snippet get 12
+//	snippet list [-mine]
+//	snippet delete 12
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const usage = `usage: snippet [-config path] <command> [arguments]
+
+commands:
+  config  -server URL -token TOKEN [-insecure]   store the server and API token
+  create  -title TITLE [-expires 1|7|365] [-allow-secrets]
+                                                 create a snippet from stdin and print its URL
+  get     ID                                     print a snippet's content
+  list    [-mine]                                list the latest snippets
+  delete  ID                                     delete one of your snippets
+`
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath(), "path of the config file")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	var err error
+	switch cmd {
+	case "config":
+		err = runConfig(*configPath, args)
+	case "create", "get", "list", "delete":
+		var cfg *config
+		cfg, err = loadConfig(*configPath)
+		if err == nil {
+			err = run(newClient(cfg), cmd, args)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "snippet:", err)
+		os.Exit(1)
+	}
+}
+
+func runConfig(path string, args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	server := flags.String("server", "https://localhost:4000", "Snippetbox server URL")
+	token := flags.String("token", "", "API token created on the server's API Tokens page")
+	insecure := flags.Bool("insecure", false, "skip TLS certificate verification")
+	flags.Parse(args)
+
+	if *token == "" {
+		return fmt.Errorf("-token is required")
+	}
+
+	cfg := &config{Server: *server, Token: *token, Insecure: *insecure}
+	if err := cfg.save(path); err != nil {
+		return err
+	}
+
+	fmt.Println("saved", path)
+	return nil
+}
+
+func run(c *client, cmd string, args []string) error {
+	switch cmd {
+	case "create":
+		flags := flag.NewFlagSet("create", flag.ExitOnError)
+		title := flags.String("title", "", "snippet title")
+		expires := flags.Int("expires", 365, "days until the snippet expires: 1, 7 or 365")
+		allowSecrets := flags.Bool("allow-secrets", false, "publish even if the content looks like it contains credentials")
+		flags.Parse(args)
+
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		s, err := c.create(*title, string(content), *expires, *allowSecrets)
+		if err != nil {
+			return err
+		}
+		fmt.Println(s.URL)
+
+	case "get":
+		id, err := idArg(args)
+		if err != nil {
+			return err
+		}
+
+		s, err := c.get(id)
+		if err != nil {
+			return err
+		}
+		fmt.Print(s.Content)
+
+	case "list":
+		flags := flag.NewFlagSet("list", flag.ExitOnError)
+		mine := flags.Bool("mine", false, "only list your own snippets")
+		flags.Parse(args)
+
+		snippets, err := c.list(*mine)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tEXPIRES")
+		for _, s := range snippets {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, s.Title, s.Created.Format("2006-01-02 15:04"), s.Expires.Format("2006-01-02"))
+		}
+		return tw.Flush()
+
+	case "delete":
+		id, err := idArg(args)
+		if err != nil {
+			return err
+		}
+
+		if err := c.delete(id); err != nil {
+			return err
+		}
+		fmt.Printf("deleted snippet #%d\n", id)
+	}
+	return nil
+}
+
+func idArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected a single snippet ID")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid snippet ID %q", args[0])
+	}
+	return id, nil
+}
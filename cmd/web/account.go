@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"snippetbox.rakesh.net/internal/mailer"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/validator"
+)
+
+type accountNameForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+type accountEmailForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type accountPasswordForm struct {
+	CurrentPassword     string `form:"current_password"`
+	NewPassword         string `form:"new_password"`
+	validator.Validator `form:"-"`
+}
+
+type accountDeleteForm struct {
+	Password            string `form:"password"`
+	Snippets            string `form:"snippets"`
+	validator.Validator `form:"-"`
+}
+
+// accountForms holds the forms of the account page, only the one that was posted has errors
+type accountForms struct {
+	NameForm     accountNameForm
+	EmailForm    accountEmailForm
+	PasswordForm accountPasswordForm
+	DeleteForm   accountDeleteForm
+}
+
+// what happens to the snippets of a deleted account
+const (
+	deleteSnippets    = "delete"
+	anonymizeSnippets = "anonymize"
+)
+
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	app.renderAccount(w, r, http.StatusOK, accountForms{})
+}
+
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, status int, forms accountForms) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//forms that weren't posted show the current values
+	if forms.NameForm.Name == "" {
+		forms.NameForm.Name = user.Name
+	}
+	if forms.EmailForm.Email == "" {
+		forms.EmailForm.Email = user.Email
+	}
+	if forms.DeleteForm.Snippets == "" {
+		forms.DeleteForm.Snippets = anonymizeSnippets
+	}
+
+	sessions, err := app.userSessions.ForUser(user.ID, app.sessionManager.IdleTimeout)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	hasPassword, err := app.users.HasPassword(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.HasPassword = hasPassword
+	data.UserSessions = sessions
+	data.CurrentSessionID = app.sessionManager.GetString(r.Context(), "userSessionID")
+	data.Form = forms
+	app.render(w, r, status, "account.tmpl", data)
+}
+
+func (app *application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	var form accountNameForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters")
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{NameForm: form})
+		return
+	}
+
+	err = app.users.SetName(app.authenticatedUserID(r), form.Name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your name has been changed")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	var form accountEmailForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must contain valid email")
+
+	id := app.authenticatedUserID(r)
+	app.checkCurrentPassword(&form.Validator, r, id, form.Password, "password")
+	if form.Valid() {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if form.Email == user.Email {
+			form.AddFieldError("email", "This is already your email address")
+		} else {
+			err = app.users.SetEmail(id, form.Email)
+			if err == nil {
+				//let the old address know, in case someone else made the change
+				app.sendMail(mailer.Message{
+					To:      user.Email,
+					Subject: "Your Snippetbox email address has changed",
+					Body: fmt.Sprintf("Hi %s,\n\nThe email address of your Snippetbox account has been changed to %s.\n\n"+
+						"If you didn't make this change, please contact us straight away.\n", user.Name, form.Email),
+				})
+
+				user.Email = form.Email
+				app.sendVerificationEmail(user)
+
+				app.audit(r, id, models.AuditEmailChange, userTarget(id))
+				app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed, we've emailed you a link to verify it")
+				http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+				return
+			}
+
+			if !errors.Is(err, models.ErrDuplicateEmail) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddFieldError("email", "Email Address is already in use")
+		}
+	}
+
+	form.Password = ""
+	app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{EmailForm: form})
+}
+
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id := app.authenticatedUserID(r)
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.checkNewPassword(&form.Validator, "new_password", form.NewPassword, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.checkCurrentPassword(&form.Validator, r, id, form.CurrentPassword, "current_password")
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{PasswordForm: accountPasswordForm{Validator: form.Validator}})
+		return
+	}
+
+	err = app.users.SetPassword(id, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//log out everywhere else, then carry on in a fresh session here
+	err = app.destroyUserSessions(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//a fresh session for this browser, remembered if the old one was
+	err = app.startUserSession(r, id, app.sessionManager.GetBool(r.Context(), "remembered"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, id, models.AuditPasswordChange, userTarget(id))
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed, and you've been logged out everywhere else")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.PermittedValue(form.Snippets, deleteSnippets, anonymizeSnippets), "snippets", "This field is invalid")
+
+	id := app.authenticatedUserID(r)
+	app.checkCurrentPassword(&form.Validator, r, id, form.Password, "password")
+
+	if !form.Valid() {
+		form.Password = ""
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{DeleteForm: form})
+		return
+	}
+
+	err = app.users.Delete(id, form.Snippets == deleteSnippets)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, id, models.AuditAccountDelete, userTarget(id))
+
+	err = app.destroyUserSessions(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.endUserSession(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// checkCurrentPassword adds a field error to v unless password is the user's current password.
+// Users without a password, who signed up with single sign-on, confirm by having just logged in
+// instead. The password is only checked once the rest of the form is valid.
+func (app *application) checkCurrentPassword(v *validator.Validator, r *http.Request, userID int, password, field string) {
+	hasPassword, err := app.users.HasPassword(userID)
+	if err == nil && !hasPassword {
+		v.CheckField(app.recentlyAuthenticated(r), field, "Please log in again to confirm this change")
+		return
+	}
+	if err == nil {
+		v.CheckField(validator.NotBlank(password), field, "This field cannot be blank")
+		if !v.Valid() {
+			return
+		}
+		err = app.users.CheckPassword(userID, password)
+	}
+	if err == nil {
+		return
+	}
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		v.AddFieldError(field, "Your password is incorrect")
+		return
+	}
+	//treat anything unexpected as a failed check rather than carrying on
+	app.logger.Error(err.Error())
+	v.AddFieldError(field, "Your password couldn't be checked, please try again")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"snippetbox.rakesh.net/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestAccountDeleteConfirmation(t *testing.T) {
+	app, ts, provider := newTestSSOServer(t)
+
+	t.Run("Password user without password", func(t *testing.T) {
+		id := newTestUser(t, app, "Bob", "bob@example.com", "correct horse battery")
+		ts.resetClient(t)
+		ts.login(t, "bob@example.com", "correct horse battery")
+
+		_, _, page := ts.get(t, "/user/account")
+		status, _, _ := ts.postForm(t, "/user/account/delete", url.Values{
+			"snippets":   {anonymizeSnippets},
+			"csrf_token": {extractCSRFToken(t, page)},
+		})
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d, want %d", status, http.StatusUnprocessableEntity)
+		}
+		if _, err := app.users.Get(id); err != nil {
+			t.Errorf("user was deleted: %v", err)
+		}
+	})
+
+	t.Run("Single sign-on user just logged in", func(t *testing.T) {
+		claims := map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+		if got := ssoLogin(t, ts, provider, claims); got != "/snippet/create" {
+			t.Fatalf("got redirect to %q, want %q", got, "/snippet/create")
+		}
+		id, err := app.identities.UserID(provider.URL, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//there's no password to ask for, so the page offers logging in again instead
+		_, _, page := ts.get(t, "/user/account")
+		if strings.Contains(page, "name='password'") || !strings.Contains(page, "/user/login/oidc?next=/user/account") {
+			t.Error("account page asks a user without a password for one")
+		}
+
+		status, header, _ := ts.postForm(t, "/user/account/delete", url.Values{
+			"snippets":   {anonymizeSnippets},
+			"csrf_token": {extractCSRFToken(t, page)},
+		})
+		if status != http.StatusSeeOther || header.Get("Location") != "/" {
+			t.Errorf("got status %d to %q, want a redirect to /", status, header.Get("Location"))
+		}
+		if _, err := app.users.Get(id); !errors.Is(err, models.ErrNoRecord) {
+			t.Errorf("user wasn't deleted: %v", err)
+		}
+	})
+}
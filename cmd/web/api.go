@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"strconv"
+	"time"
+)
+
+// maximum size of a JSON request body
+const maxAPIBodySize = 1 << 20
+
+// apiSnippet is the JSON representation of a snippet
+type apiSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"url"`
+}
+
+// apiSnippetInput is the body of a create request, expires is in days
+type apiSnippetInput struct {
+	Title        string `json:"title"`
+	Content      string `json:"content"`
+	Expires      int    `json:"expires"`
+	AllowSecrets bool   `json:"allow_secrets"`
+}
+
+func (app *application) newAPISnippet(s *models.Snippet) apiSnippet {
+	return apiSnippet{
+		ID:      s.ID,
+		Title:   s.Title,
+		Content: s.Content,
+		Created: s.Created,
+		Expires: s.Expires,
+		URL:     app.snippetURL(s.ID),
+	}
+}
+
+// apiSnippetList returns the latest snippets, or the caller's own latest snippets with ?mine=true
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	var snippets []*models.Snippet
+	var err error
+
+	if mine, _ := strconv.ParseBool(r.URL.Query().Get("mine")); mine {
+		if !app.isAuthenticated(r) {
+			app.invalidAPIToken(w, r)
+			return
+		}
+		snippets, err = app.snippets.LatestByUser(app.authenticatedUserID(r))
+	} else {
+		snippets, err = app.snippets.Latest()
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	out := make([]apiSnippet, 0, len(snippets))
+	for _, s := range snippets {
+		out = append(out, app.newAPISnippet(s))
+	}
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{"snippets": out})
+}
+
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		return
+	}
+
+	snippet, err := app.snippets.Get(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{"snippet": app.newAPISnippet(snippet)})
+}
+
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !user.EmailVerified {
+		app.apiError(w, r, http.StatusForbidden, "verify your email address before creating snippets")
+		return
+	}
+
+	var input apiSnippetInput
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		app.apiError(w, r, http.StatusBadRequest, "request body must be a JSON object with title, content and expires")
+		return
+	}
+
+	//the same rules as the create form
+	form := snippetCreateForm{Title: input.Title, Content: input.Content, Expires: input.Expires, AllowSecrets: input.AllowSecrets}
+	form.validate()
+	findings := form.checkSecrets()
+
+	if !form.Valid() {
+		app.writeJSON(w, r, http.StatusUnprocessableEntity, map[string]any{"errors": form.FieldErrors})
+		return
+	}
+
+	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if len(findings) > 0 {
+		err = app.acknowledgeSecrets(id, findings)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.notifySnippetCreated(r, id, form)
+	app.audit(r, app.authenticatedUserID(r), models.AuditSnippetCreate, snippetTarget(id))
+
+	snippet, err := app.snippets.Get(id, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", app.snippetURL(id))
+	app.writeJSON(w, r, http.StatusCreated, map[string]any{"snippet": app.newAPISnippet(snippet)})
+}
+
+// apiSnippetDelete deletes one of the caller's snippets
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		return
+	}
+
+	err = app.snippets.Delete(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, r, http.StatusNotFound, "snippet not found")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/validator"
+	"strconv"
+	"time"
+)
+
+// number of events on each page of the admin audit log
+const auditPageSize = 100
+
+// auditFilterForm is the query string of the audit log page and its export, dates are YYYY-MM-DD
+type auditFilterForm struct {
+	Action              string `form:"action"`
+	Actor               int    `form:"actor"`
+	Target              string `form:"target"`
+	Since               string `form:"since"`
+	Until               string `form:"until"`
+	Before              int64  `form:"before"`
+	validator.Validator `form:"-"`
+}
+
+// auditEventJSON is an audit event as one line of the JSON lines export
+type auditEventJSON struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	ActorID   int       `json:"actor_id,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// audit appends an event to the audit log. actorID is the user who did it, or 0 if
+// nobody is logged in. Failing to record an event is logged rather than failing the
+// request, since the action itself has usually happened already.
+func (app *application) audit(r *http.Request, actorID int, action, target string) {
+	err := app.auditEvents.Insert(&models.AuditEvent{
+		ActorID:   actorID,
+		Action:    action,
+		Target:    target,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		app.logger.Error("recording audit event failed", "action", action, "actor", actorID, "target", target, "request_id", requestID(r), "error", err)
+	}
+}
+
+// userTarget and snippetTarget name the targets of audit events
+func userTarget(id int) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+func snippetTarget(id int) string {
+	return fmt.Sprintf("snippet:%d", id)
+}
+
+// parseAuditFilter reads the filter from the query string, field errors are left on the form
+func (app *application) parseAuditFilter(r *http.Request) (auditFilterForm, models.AuditFilter, error) {
+	var form auditFilterForm
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		return form, models.AuditFilter{}, err
+	}
+
+	filter := models.AuditFilter{Action: form.Action, ActorID: form.Actor, Target: form.Target}
+
+	if form.Action != "" {
+		form.CheckField(validator.PermittedValue(form.Action, models.AuditActions...), "action", "This field is invalid")
+	}
+	if form.Since != "" {
+		filter.Since, err = time.Parse(time.DateOnly, form.Since)
+		form.CheckField(err == nil, "since", "This field must be a date")
+	}
+	if form.Until != "" {
+		//until is inclusive on the page, so it's the start of the next day
+		until, err := time.Parse(time.DateOnly, form.Until)
+		form.CheckField(err == nil, "until", "This field must be a date")
+		filter.Until = until.AddDate(0, 0, 1)
+	}
+	return form, filter, nil
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	form, filter, err := app.parseAuditFilter(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.AuditActions = models.AuditActions
+
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "admin_audit.tmpl", data)
+		return
+	}
+
+	events, err := app.auditEvents.List(filter, form.Before, auditPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.AuditEvents = events
+
+	//the next page carries on from the oldest event on this one, with the same filter
+	if len(events) == auditPageSize {
+		q := r.URL.Query()
+		q.Set("before", strconv.FormatInt(events[len(events)-1].ID, 10))
+		data.AuditNextURL = "/admin/audit?" + q.Encode()
+	}
+
+	q := r.URL.Query()
+	q.Del("before")
+	data.AuditExportURL = (&url.URL{Path: "/admin/audit/export", RawQuery: q.Encode()}).String()
+
+	app.render(w, r, http.StatusOK, "admin_audit.tmpl", data)
+}
+
+// adminAuditExport streams the events matching the filter as JSON lines, oldest first, for importing into a SIEM
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	form, filter, err := app.parseAuditFilter(r)
+	if err != nil || !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("snippetbox-audit-%s.jsonl", time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	//streamed like the user export, so the write deadline is pushed forward as it goes
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	err = app.auditEvents.Each(filter, func(e *models.AuditEvent) error {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return enc.Encode(auditEventJSON{
+			ID:        e.ID,
+			Time:      e.Created,
+			ActorID:   e.ActorID,
+			Action:    e.Action,
+			Target:    e.Target,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+		})
+	})
+	if err != nil {
+		//the headers have already been sent, the client is left with a truncated file
+		app.logger.Error("audit export failed", "request_id", requestID(r), "error", err)
+	}
+}
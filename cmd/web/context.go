@@ -3,3 +3,11 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+const authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+
+const userRoleContextKey = contextKey("userRole")
+
+const emailVerifiedContextKey = contextKey("emailVerified")
+
+const requestIDContextKey = contextKey("requestID")
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// comment lines are sent this often to keep idle streams and proxies alive
+	eventsHeartbeat = 15 * time.Second
+
+	// streams are closed after this long, EventSource reconnects on its own after eventsRetry
+	eventsMaxLifetime = time.Hour
+	eventsRetry       = 5 * time.Second
+
+	// events for subscribers that fall this far behind are dropped
+	eventsBuffer = 16
+)
+
+// snippetEvent is pushed to the home page when a new snippet is created
+type snippetEvent struct {
+	ID           int       `json:"id"`
+	Title        string    `json:"title"`
+	Created      time.Time `json:"created"`
+	CreatedHuman string    `json:"createdHuman"`
+}
+
+// snippetBroadcaster fans snippet events out to every connected /events stream in this process
+type snippetBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan snippetEvent]struct{}
+}
+
+func newSnippetBroadcaster() *snippetBroadcaster {
+	return &snippetBroadcaster{subs: map[chan snippetEvent]struct{}{}}
+}
+
+// Subscribe returns a channel of events and a function to stop receiving them
+func (b *snippetBroadcaster) Subscribe() (<-chan snippetEvent, func()) {
+	ch := make(chan snippetEvent, eventsBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Publish never blocks, a subscriber whose buffer is full misses the event
+func (b *snippetBroadcaster) Publish(ev snippetEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// events streams newly created snippets as Server-Sent Events
+func (app *application) events(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	//the server's WriteTimeout would otherwise cut the stream off, so the deadline is pushed
+	//forward before every write instead; a client that stops reading still times out
+	extend := func() error {
+		return rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+
+	if err := extend(); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	events, unsubscribe := app.broadcaster.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	lifetime := time.NewTimer(eventsMaxLifetime)
+	defer lifetime.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-lifetime.C:
+			return
+		case <-heartbeat.C:
+			if extend() != nil {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				app.logger.Error(err.Error(), "request_id", requestID(r))
+				continue
+			}
+			if extend() != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: snippet\ndata: %s\n\n", ev.ID, data)
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
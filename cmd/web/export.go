@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"snippetbox.rakesh.net/internal/models"
+	"strings"
+	"time"
+)
+
+// name of the metadata file stored at the root of every export archive
+const manifestName = "manifest.json"
+
+// snippets have no language yet, so every file is exported as plain text
+const snippetFileExt = ".txt"
+
+// exportManifest describes the contents of an export archive
+type exportManifest struct {
+	Version  int                     `json:"version"`
+	Exported time.Time               `json:"exported"`
+	Snippets []exportManifestSnippet `json:"snippets"`
+}
+
+// exportManifestSnippet holds the metadata of a single exported snippet
+type exportManifestSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	File    string    `json:"file"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+var slugRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// snippetFileName builds the archive file name for a snippet from its title and ID, e.g. "hello-world-12.txt"
+func snippetFileName(s *models.Snippet) string {
+	slug := strings.Trim(slugRX.ReplaceAllString(strings.ToLower(s.Title), "-"), "-")
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	if slug == "" {
+		return fmt.Sprintf("snippet-%d%s", s.ID, snippetFileExt)
+	}
+	return fmt.Sprintf("%s-%d%s", slug, s.ID, snippetFileExt)
+}
+
+// snippetArchiveWriter writes snippets straight into a zip stream, one file at a time,
+// and finishes the archive with the manifest
+type snippetArchiveWriter struct {
+	zw       *zip.Writer
+	manifest exportManifest
+}
+
+func newSnippetArchiveWriter(w io.Writer) *snippetArchiveWriter {
+	return &snippetArchiveWriter{
+		zw: zip.NewWriter(w),
+		manifest: exportManifest{
+			Version:  1,
+			Exported: time.Now().UTC(),
+			Snippets: []exportManifestSnippet{},
+		},
+	}
+}
+
+func (aw *snippetArchiveWriter) Add(s *models.Snippet) error {
+	name := snippetFileName(s)
+
+	f, err := aw.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: s.Created,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, s.Content); err != nil {
+		return err
+	}
+
+	aw.manifest.Snippets = append(aw.manifest.Snippets, exportManifestSnippet{
+		ID:      s.ID,
+		Title:   s.Title,
+		File:    name,
+		Created: s.Created,
+		Expires: s.Expires,
+	})
+	return nil
+}
+
+// Close writes the manifest and the zip central directory
+func (aw *snippetArchiveWriter) Close() error {
+	f, err := aw.zw.Create(manifestName)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(aw.manifest); err != nil {
+		return err
+	}
+
+	return aw.zw.Close()
+}
+
+// writeCounter counts the bytes written through it, so a handler streaming a response
+// can tell whether the client has been sent anything yet
+type writeCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (wc *writeCounter) Write(p []byte) (int, error) {
+	n, err := wc.w.Write(p)
+	wc.n += int64(n)
+	return n, err
+}
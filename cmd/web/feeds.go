@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"strconv"
+	"time"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	ID        string   `xml:"id"`
+	Updated   string   `xml:"updated"`
+	Published string   `xml:"published"`
+	Link      atomLink `xml:"link"`
+	Content   atomText `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// feed holds the snippets and metadata shared by the Atom and RSS representations
+type feed struct {
+	Title    string
+	BaseURL  string
+	SelfPath string
+	Snippets []*models.Snippet
+}
+
+// Updated is the creation time of the newest snippet, snippets can't be edited so that is when the feed last changed
+func (f *feed) Updated() time.Time {
+	if len(f.Snippets) == 0 {
+		return time.Time{}
+	}
+	return f.Snippets[0].Created.UTC()
+}
+
+// snippetURL is also used as the entry ID, so it must not change for a given snippet
+func (f *feed) snippetURL(s *models.Snippet) string {
+	return fmt.Sprintf("%s/snippet/view/%d", f.BaseURL, s.ID)
+}
+
+// loadFeed reads the latest snippets, or the latest snippets of one user when the "user" query parameter is set
+func (app *application) loadFeed(r *http.Request, selfPath string) (*feed, error) {
+	f := &feed{
+		Title:    "Snippetbox - Latest Snippets",
+		BaseURL:  app.baseURL,
+		SelfPath: selfPath,
+	}
+
+	userParam := r.URL.Query().Get("user")
+	if userParam == "" {
+		snippets, err := app.snippets.Latest()
+		if err != nil {
+			return nil, err
+		}
+		f.Snippets = snippets
+		return f, nil
+	}
+
+	userID, err := strconv.Atoi(userParam)
+	if err != nil || userID < 1 {
+		return nil, models.ErrNoRecord
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	snippets, err := app.snippets.LatestByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Title = fmt.Sprintf("Snippetbox - Snippets by %s", user.Name)
+	f.SelfPath = fmt.Sprintf("%s?user=%d", selfPath, userID)
+	f.Snippets = snippets
+	return f, nil
+}
+
+func (app *application) feedAtom(w http.ResponseWriter, r *http.Request) {
+	f, ok := app.feedOrError(w, r, "/feed.atom")
+	if !ok {
+		return
+	}
+
+	updated := f.Updated()
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
+
+	out := atomFeed{
+		Title:   f.Title,
+		ID:      f.BaseURL + f.SelfPath,
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.BaseURL + f.SelfPath},
+			{Rel: "alternate", Type: "text/html", Href: f.BaseURL + "/"},
+		},
+	}
+
+	for _, s := range f.Snippets {
+		created := s.Created.UTC().Format(time.RFC3339)
+		out.Entries = append(out.Entries, atomEntry{
+			Title:     s.Title,
+			ID:        f.snippetURL(s),
+			Updated:   created,
+			Published: created,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: f.snippetURL(s)},
+			Content:   atomText{Type: "text", Body: s.Content},
+		})
+	}
+
+	app.serveFeed(w, r, "application/atom+xml; charset=utf-8", out, f.Updated())
+}
+
+func (app *application) feedRSS(w http.ResponseWriter, r *http.Request) {
+	f, ok := app.feedOrError(w, r, "/feed.rss")
+	if !ok {
+		return
+	}
+
+	out := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.BaseURL + "/",
+			Description: "The latest snippets shared on Snippetbox",
+		},
+	}
+	if updated := f.Updated(); !updated.IsZero() {
+		out.Channel.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+
+	for _, s := range f.Snippets {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       s.Title,
+			Link:        f.snippetURL(s),
+			GUID:        rssGUID{IsPermaLink: true, Value: f.snippetURL(s)},
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			Description: s.Content,
+		})
+	}
+
+	app.serveFeed(w, r, "application/rss+xml; charset=utf-8", out, f.Updated())
+}
+
+func (app *application) feedOrError(w http.ResponseWriter, r *http.Request, selfPath string) (*feed, bool) {
+	f, err := app.loadFeed(r, selfPath)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return f, true
+}
+
+// serveFeed encodes the feed and lets http.ServeContent answer conditional
+// GETs using the ETag and the time of the newest snippet
+func (app *application) serveFeed(w http.ResponseWriter, r *http.Request, contentType string, v any, modified time.Time) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes())))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"io"
 	"snippetbox.rakesh.net/internal/validator"
 	//"html/template"
 
 	//"html/template"
 	"net/http"
+	"net/url"
+	"snippetbox.rakesh.net/internal/mailer"
 	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/secrets"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type userSignupForm struct {
@@ -23,21 +30,111 @@ type userSignupForm struct {
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	Remember            bool   `form:"remember"`
 	validator.Validator `form:"-"`
 }
 
+type forgotPasswordForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type resetPasswordForm struct {
+	Token               string `form:"token"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// how long an emailed password reset link works for
+const passwordResetTTL = time.Hour
+
 type snippetCreateForm struct {
 	Title               string `form:"title"`
 	Content             string `form:"content"`
 	Expires             int    `form:"expires"`
+	AllowSecrets        bool   `form:"allow_secrets"`
+	SecretsFound        bool   `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+type snippetImportForm struct {
+	validator.Validator `form:"-"`
+}
+
+type webhookCreateForm struct {
+	URL                 string   `form:"url"`
+	Secret              string   `form:"secret"`
+	Events              []string `form:"events"`
+	validator.Validator `form:"-"`
+}
+
+type apiTokenCreateForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+type userRoleForm struct {
+	Role                string `form:"role"`
+	validator.Validator `form:"-"`
+}
+
+type snippetReportForm struct {
+	Reason              string `form:"reason"`
+	Comment             string `form:"comment"`
 	validator.Validator `form:"-"`
 }
 
+// maximum size in bytes of a snippet's content, which is what the TEXT column holds
+const maxSnippetContentSize = 65535
+
+// reasons a snippet can be reported for
+var reportReasons = []string{"Spam", "Abusive content", "Leaked credentials or personal data", "Other"}
+
+// each IP address can send at most maxReportsPerWindow reports per reportWindow
+const (
+	maxReportsPerWindow = 5
+	reportWindow        = time.Hour
+)
+
+// validate runs the validation checks on the snippetCreateForm instance, it is shared by the create form and imports
+func (form *snippetCreateForm) validate() {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(len(form.Content) <= maxSnippetContentSize, "content", "This field cannot be more than 64KB")
+	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must be equal to 1,7 or 365")
+}
+
+// checkSecrets scans the content for credentials, unless the author has already
+// confirmed they want to publish them, and reports where they were found.
+// SecretsFound tells create.tmpl to offer the override checkbox
+func (form *snippetCreateForm) checkSecrets() []secrets.Finding {
+	findings := secrets.Scan(form.Content)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	form.SecretsFound = true
+	if !form.AllowSecrets {
+		form.AddFieldError("content", fmt.Sprintf("This looks like it contains secrets (%s). Remove them, or confirm below that you want to publish them anyway", describeFindings(findings)))
+	}
+	return findings
+}
+
+// describeFindings lists the kind and line of each secret found, e.g. "AWS access key on line 3"
+func describeFindings(findings []secrets.Finding) string {
+	found := make([]string, 0, len(findings))
+	for _, f := range findings {
+		found = append(found, fmt.Sprintf("%s on line %d", f.Kind, f.Line))
+	}
+	return strings.Join(found, ", ")
+}
+
 // Home handler for the root URL ("/")
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	snippets, err := app.snippets.Latest()
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -45,7 +142,7 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	data.Snippets = snippets
 
 	//use the new render helper
-	app.render(w, http.StatusOK, "home.tmpl", data)
+	app.render(w, r, http.StatusOK, "home.tmpl", data)
 }
 
 // Snippet view handler (to view a specific snippet)
@@ -58,20 +155,85 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippets.Get(id, app.authenticatedUserID(r))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
+	data.Form = snippetReportForm{}
+	data.ReportReasons = reportReasons
+
+	app.render(w, r, http.StatusOK, "view.tmpl", data)
+}
+
+func (app *application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.snippets.Get(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	var form snippetReportForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.PermittedValue(form.Reason, reportReasons...), "reason", "Please choose a reason")
+	form.CheckField(validator.MaxChars(form.Comment, 1000), "comment", "This field cannot be more than 1000 characters")
+
+	status := http.StatusUnprocessableEntity
+
+	ip := clientIP(r)
+	recent, err := app.reports.CountRecentByIP(ip, reportWindow)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if recent >= maxReportsPerWindow {
+		form.AddNonFieldError("You have sent too many reports, please try again later")
+		status = http.StatusTooManyRequests
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		data.ReportReasons = reportReasons
+		app.render(w, r, status, "view.tmpl", data)
+		return
+	}
 
-	app.render(w, http.StatusOK, "view.tmpl", data)
+	err = app.reports.Insert(id, app.authenticatedUserID(r), ip, form.Reason, form.Comment)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Thanks for your report, a moderator will review it")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +243,7 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 		Expires: 365,
 	}
 
-	app.render(w, http.StatusOK, "create.tmpl", data)
+	app.render(w, r, http.StatusOK, "create.tmpl", data)
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
@@ -93,28 +255,36 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Validation checks on the snippetCreateForm instance
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must be equal to 1,7 or 365")
+	form.validate()
+	findings := form.checkSecrets()
 
 	// If there are any validation errors, re-display the create.tmpl template
 	// with the snippetCreateForm instance as dynamic data
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.tmpl", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl", data)
 		return
 	}
 
 	// Insert the data into the database and handle any errors
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires, app.authenticatedUserID(r))
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
+	if len(findings) > 0 {
+		err = app.acknowledgeSecrets(id, findings)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.notifySnippetCreated(r, id, form)
+	app.audit(r, app.authenticatedUserID(r), models.AuditSnippetCreate, snippetTarget(id))
+
 	//flash message after successfully creating the snippet
 	app.sessionManager.Put(r.Context(), "flash", "Snippet created successfully")
 
@@ -122,13 +292,113 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
+func (app *application) snippetImport(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = snippetImportForm{}
+	app.render(w, r, http.StatusOK, "import.tmpl", data)
+}
+
+func (app *application) snippetImportPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetImportForm
+
+	//noSurf has already parsed the multipart form while checking the CSRF token
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		if !errors.Is(err, http.ErrMissingFile) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		form.AddFieldError("file", "Please choose a file to import")
+	}
+
+	var items []importItem
+	if file != nil {
+		defer file.Close()
+
+		content, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if len(content) > maxImportSize {
+			form.AddFieldError("file", "This file is too large")
+		} else if items, err = readImport(content); err != nil {
+			form.AddFieldError("file", err.Error())
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "import.tmpl", data)
+		return
+	}
+
+	results, err := app.importSnippets(items, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, result := range results {
+		if result.ID != 0 {
+			app.audit(r, app.authenticatedUserID(r), models.AuditSnippetCreate, snippetTarget(result.ID))
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.ImportResults = results
+	app.render(w, r, http.StatusOK, "import.tmpl", data)
+}
+
+// acknowledgeSecrets records that a snippet was published despite the secret scanner's findings
+func (app *application) acknowledgeSecrets(id int, findings []secrets.Finding) error {
+	kinds := make([]string, 0, len(findings))
+	for _, f := range findings {
+		kinds = append(kinds, fmt.Sprintf("%s (line %d)", f.Kind, f.Line))
+	}
+	app.logger.Info("snippet published with possible secrets", "snippet", id, "secrets", strings.Join(kinds, ", "))
+
+	return app.snippets.AcknowledgeSecrets(id)
+}
+
+// notifySnippetCreated tells live home pages and webhooks about a snippet that has just been inserted
+func (app *application) notifySnippetCreated(r *http.Request, id int, form snippetCreateForm) {
+	now := time.Now().UTC()
+	app.broadcaster.Publish(snippetEvent{
+		ID:           id,
+		Title:        form.Title,
+		Created:      now,
+		CreatedHuman: humanDate(now),
+	})
+	app.enqueueWebhookEvent(app.authenticatedUserID(r), eventSnippetCreated, webhookSnippet{
+		ID:      id,
+		Title:   form.Title,
+		URL:     app.snippetURL(id),
+		Created: now,
+		Expires: now.AddDate(0, 0, form.Expires),
+	})
+}
+
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	if !app.localSignup {
+		app.signupDisabled(w, r)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.tmpl", data)
+	app.render(w, r, http.StatusOK, "signup.tmpl", data)
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	if !app.localSignup {
+		app.signupDisabled(w, r)
+		return
+	}
+
 	var form userSignupForm
 
 	err := app.decodePostForm(r, &form)
@@ -140,40 +410,56 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
 	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
 	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must contain valid email")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	err = app.checkNewPassword(&form.Validator, "password", form.Password, form.Name, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
 		return
 	}
 
 	//creating a record of new user in the DB
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email Address is already in use")
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "User signed up successfully, Please Login")
+	app.audit(r, id, models.AuditSignup, userTarget(id))
+	app.sendVerificationEmail(&models.User{ID: id, Name: form.Name, Email: form.Email})
+
+	app.sessionManager.Put(r.Context(), "flash", "User signed up successfully, we've emailed you a link to verify your address, Please Login")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
 
+// signupDisabled sends people to the login page when signing up with a password is turned off
+func (app *application) signupDisabled(w http.ResponseWriter, r *http.Request) {
+	if app.oidc != nil {
+		app.sessionManager.Put(r.Context(), "flash", "Please sign up by logging in with "+app.oidc.name)
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Signing up is disabled, please ask an administrator for an account")
+	}
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.tmpl", data)
+	app.render(w, r, http.StatusOK, "login.tmpl", data)
 }
 
 func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -192,52 +478,821 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
+		return
+	}
+
+	//too many failures from this address, don't even check the credentials
+	err = app.loginThrottle.Check(clientIP(r))
+	if err != nil {
+		if errors.Is(err, models.ErrLoginThrottled) {
+			app.audit(r, 0, models.AuditLoginFailed, form.Email)
+			form.AddNonFieldError("Too many failed login attempts from your network. Please try again later")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusTooManyRequests, "login.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
 	//checking whether the credentials are valid
 	id, err := app.users.Authenticate(form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or Password is incorrect")
+		if errors.Is(err, models.ErrInvalidCredentials) || errors.Is(err, models.ErrAccountDisabled) || errors.Is(err, models.ErrAccountLocked) {
+			//the target is the email address that was tried, whether or not there's an account with it
+			app.audit(r, 0, models.AuditLoginFailed, form.Email)
+			status := http.StatusUnprocessableEntity
+
+			switch {
+			case errors.Is(err, models.ErrAccountDisabled):
+				form.AddNonFieldError("This account has been disabled")
+			case errors.Is(err, models.ErrAccountLocked):
+				form.AddNonFieldError("This account is temporarily locked after too many failed login attempts. Please try again later")
+				status = http.StatusTooManyRequests
+			default:
+				form.AddNonFieldError("Email or Password is incorrect")
+
+				err = app.loginThrottle.RecordFailure(clientIP(r))
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+			}
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data)
+			app.render(w, r, status, "login.tmpl", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	//changing the session id, its good practice to do when the authentication state or privilege levels changes for the user
-	err = app.sessionManager.RenewToken(r.Context())
+	if !app.unverifiedLogin {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !user.EmailVerified {
+			form.AddNonFieldError("Please verify your email address before logging in")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusForbidden, "login.tmpl", data)
+			return
+		}
+	}
+
+	//with two-factor authentication on, the password only gets the user as far as the code form
+	twoFactor, err := app.twoFactor.Enabled(id)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+	if twoFactor {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+		app.sessionManager.Put(r.Context(), "twoFactorStarted", time.Now().Unix())
+		app.sessionManager.Put(r.Context(), "twoFactorRemember", form.Remember)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
 		return
 	}
 
+	app.completeLogin(w, r, id, form.Remember)
+}
+
+// completeLogin logs the user in once they have proven who they are
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, id int, remember bool) {
 	//add the id of the current user to the session so that they are now logged in
-	app.sessionManager.Put(r.Context(), "authenticatedID", id)
+	err := app.startUserSession(r, id, remember)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, id, models.AuditLogin, userTarget(id))
 
-	//redirect the user to the create snippet page
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	//redirect the user to where they were headed, or else the create snippet page
+	redirect := app.sessionManager.PopString(r.Context(), "loginRedirect")
+	if redirect == "" {
+		redirect = "/snippet/create"
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
 }
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	//change the session id again
-	err := app.sessionManager.RenewToken(r.Context())
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = forgotPasswordForm{}
+	app.render(w, r, http.StatusOK, "forgot_password.tmpl", data)
+}
+
+func (app *application) forgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	//remove the authenticatedUserID from the session data so that the user is logged out
-	app.sessionManager.Remove(r.Context(), "authenticatedID")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must contain valid email address")
 
-	//add a flash message to the session to confirm to the user that they've been logged out
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot_password.tmpl", data)
+		return
+	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	//the response is the same whether or not the account exists, so this can't be used to find out who has signed up
+	id, err := app.users.IDByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if id != 0 {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !user.Disabled {
+			token, err := app.passwordResets.New(user.ID, passwordResetTTL)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			link := app.absoluteURL("/user/password/reset?token=" + url.QueryEscape(token))
+			app.sendMail(mailer.Message{
+				To:      user.Email,
+				Subject: "Reset your Snippetbox password",
+				Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Snippetbox account. "+
+					"If it was you, follow this link within the next hour to choose a new one:\n\n%s\n\n"+
+					"If it wasn't you, you can ignore this email and your password won't change.\n", user.Name, link),
+			})
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If an account uses that email address, we've sent it a link to reset the password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := app.passwordResets.UserID(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.invalidResetToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = resetPasswordForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset_password.tmpl", data)
+}
+
+func (app *application) invalidResetToken(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired, please ask for a new one")
+	http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+}
+
+func (app *application) resetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form resetPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	//the user's name and email address are needed to judge how guessable the password is
+	id, err := app.passwordResets.UserID(form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.invalidResetToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.checkNewPassword(&form.Validator, "password", form.Password, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset_password.tmpl", data)
+		return
+	}
+
+	//the token is used up before the password changes, so it can't be used twice
+	id, err = app.passwordResets.Consume(form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.invalidResetToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.SetPassword(id, form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//proving access to the mailbox is enough to lift a lockout after failed logins
+	err = app.users.Unlock(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, id, models.AuditPasswordReset, userTarget(id))
+
+	//whoever knew the old password may still be logged in, log them out everywhere including this browser
+	err = app.destroyUserSessions(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.endUserSession(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset, Please Login")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+	app.audit(r, id, models.AuditLogout, userTarget(id))
+
+	//change the session id again and remove the authenticatedUserID from the session data so that the user is logged out
+	err := app.endUserSession(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//add a flash message to the session to confirm to the user that they've been logged out
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) userExport(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	filename := fmt.Sprintf("snippetbox-export-%s.zip", time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	//the archive is streamed straight to the client instead of being buffered like render does,
+	//so push the write deadline forward for every snippet to keep large exports within WriteTimeout
+	rc := http.NewResponseController(w)
+	out := &writeCounter{w: w}
+	archive := newSnippetArchiveWriter(out)
+
+	err := app.snippets.EachByUser(userID, func(s *models.Snippet) error {
+		rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return archive.Add(s)
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		//the zip writer buffers its output, so an early failure hasn't sent anything yet
+		//and can still be answered with an error page instead of an empty archive
+		if out.n == 0 {
+			w.Header().Del("Content-Disposition")
+			app.serverError(w, r, err)
+			return
+		}
+
+		//the headers have already been sent, so all we can do is log the error,
+		//the client is left with a truncated archive without a central directory
+		app.logger.Error("export failed", "user", userID, "request_id", requestID(r), "error", err)
+	}
+}
+
+func (app *application) webhookList(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.webhooks.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhooks = webhooks
+	data.WebhookEvents = webhookEvents
+	data.Form = webhookCreateForm{Events: webhookEvents}
+	app.render(w, r, http.StatusOK, "webhooks.tmpl", data)
+}
+
+func (app *application) webhookCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form webhookCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.URL), "url", "This field cannot be blank")
+	form.CheckField(validator.ValidURL(form.URL), "url", "This field must be a valid http or https URL")
+	form.CheckField(!isInternalWebhookURL(form.URL), "url", "Webhooks can't be sent to this server or its network")
+	form.CheckField(validator.MaxChars(form.URL, 2048), "url", "This field cannot be more than 2048 characters")
+	form.CheckField(validator.MaxChars(form.Secret, 255), "secret", "This field cannot be more than 255 characters")
+	form.CheckField(len(form.Events) > 0, "events", "Select at least one event")
+	for _, event := range form.Events {
+		form.CheckField(validator.PermittedValue(event, webhookEvents...), "events", "Unknown event type")
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	if !form.Valid() {
+		webhooks, err := app.webhooks.ForUser(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Webhooks = webhooks
+		data.WebhookEvents = webhookEvents
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "webhooks.tmpl", data)
+		return
+	}
+
+	//generate a secret if the user didn't provide one
+	if form.Secret == "" {
+		form.Secret, err = newWebhookSecret()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	id, err := app.webhooks.Insert(userID, form.URL, form.Secret, form.Events)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userID, models.AuditWebhookCreate, userTarget(userID))
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook added successfully")
+
+	http.Redirect(w, r, fmt.Sprintf("/user/webhooks/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) webhookView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	webhook, err := app.webhooks.Get(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	deliveries, err := app.webhooks.Deliveries(webhook.ID, 50)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhook = webhook
+	data.WebhookDeliveries = deliveries
+	app.render(w, r, http.StatusOK, "webhook.tmpl", data)
+}
+
+func (app *application) webhookDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.webhooks.Delete(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook deleted")
+
+	http.Redirect(w, r, "/user/webhooks", http.StatusSeeOther)
+}
+
+func (app *application) apiTokenList(w http.ResponseWriter, r *http.Request) {
+	app.renderAPITokens(w, r, http.StatusOK, apiTokenCreateForm{}, "")
+}
+
+func (app *application) apiTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters")
+
+	if !form.Valid() {
+		app.renderAPITokens(w, r, http.StatusUnprocessableEntity, form, "")
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	token, err := app.apiTokens.New(userID, form.Name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userID, models.AuditAPITokenCreate, userTarget(userID))
+
+	//the token is shown once on this response rather than after a redirect, so it never ends up in the session
+	app.renderAPITokens(w, r, http.StatusOK, apiTokenCreateForm{}, token)
+}
+
+func (app *application) apiTokenDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	err = app.apiTokens.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.audit(r, userID, models.AuditAPITokenRevoke, userTarget(userID))
+
+	app.sessionManager.Put(r.Context(), "flash", "API token revoked")
+
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+func (app *application) renderAPITokens(w http.ResponseWriter, r *http.Request, status int, form apiTokenCreateForm, newToken string) {
+	tokens, err := app.apiTokens.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.APITokens = tokens
+	data.NewAPIToken = newToken
+	data.Form = form
+	app.render(w, r, status, "tokens.tmpl", data)
+}
+
+// adminStats are the counts shown on the admin dashboard
+type adminStats struct {
+	Users            int
+	DisabledUsers    int
+	ActiveSnippets   int
+	ExpiredSnippets  int
+	Sessions         int
+	LoggedInSessions int
+	LoggedInUsers    int
+}
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	var stats adminStats
+	var err error
+
+	stats.Users, stats.DisabledUsers, err = app.users.Counts()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	stats.ActiveSnippets, stats.ExpiredSnippets, err = app.snippets.Counts()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//walk the unexpired sessions in the scs store to count who is logged in
+	loggedIn := map[int]bool{}
+	err = app.sessionManager.Iterate(r.Context(), func(ctx context.Context) error {
+		stats.Sessions++
+		if id := app.sessionManager.GetInt(ctx, "authenticatedID"); id != 0 {
+			stats.LoggedInSessions++
+			loggedIn[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	stats.LoggedInUsers = len(loggedIn)
+
+	data := app.newTemplateData(r)
+	data.AdminStats = stats
+	app.render(w, r, http.StatusOK, "admin.tmpl", data)
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	users, err := app.users.Search(query, 100)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Roles = models.Roles
+	data.Query = query
+	app.render(w, r, http.StatusOK, "admin_users.tmpl", data)
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	var form userRoleForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	//admins can't change their own role, so there is always at least one admin left
+	if !validator.PermittedValue(form.Role, models.Roles...) || id == app.authenticatedUserID(r) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetRole(id, form.Role)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.authenticatedUserID(r), models.AuditRoleChange, userTarget(id))
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Role of user #%d changed to %s", id, form.Role))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+// adminUserUnlockPost lifts a lockout after failed logins
+func (app *application) adminUserUnlockPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.users.Unlock(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.authenticatedUserID(r), models.AuditUserUnlock, userTarget(id))
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("User #%d has been unlocked", id))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	//admins can't lock themselves out
+	if id == app.authenticatedUserID(r) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetDisabled(id, disabled)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if disabled {
+		app.audit(r, app.authenticatedUserID(r), models.AuditUserDisable, userTarget(id))
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("User #%d has been disabled", id))
+	} else {
+		app.audit(r, app.authenticatedUserID(r), models.AuditUserEnable, userTarget(id))
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("User #%d has been enabled", id))
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippets.Recent(100)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	app.render(w, r, http.StatusOK, "admin_snippets.tmpl", data)
+}
+
+func (app *application) adminSnippetExpirePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSnippetAction(w, r, app.snippets.Expire, models.AuditSnippetExpire, "Snippet #%d has been expired")
+}
+
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSnippetAction(w, r, app.snippets.DeleteAny, models.AuditSnippetDelete, "Snippet #%d has been deleted")
+}
+
+// adminSnippetAction applies action to the snippet in the URL, records it in the audit log as auditAction
+// and redirects back to the snippet list
+func (app *application) adminSnippetAction(w http.ResponseWriter, r *http.Request, action func(int) error, auditAction, flash string) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = action(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.audit(r, app.authenticatedUserID(r), auditAction, snippetTarget(id))
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf(flash, id))
+
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (app *application) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	reports, err := app.reports.Open(100)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Reports = reports
+	app.render(w, r, http.StatusOK, "moderation.tmpl", data)
+}
+
+func (app *application) moderationHidePost(w http.ResponseWriter, r *http.Request) {
+	app.moderateSnippet(w, r, app.reports.HideSnippet, models.AuditSnippetHide, "Snippet #%d has been hidden")
+}
+
+func (app *application) moderationDeletePost(w http.ResponseWriter, r *http.Request) {
+	//the snippet's reports are deleted along with it
+	deleteSnippet := func(id, moderatorID int) error {
+		return app.snippets.DeleteAny(id)
+	}
+	app.moderateSnippet(w, r, deleteSnippet, models.AuditSnippetDelete, "Snippet #%d has been deleted")
+}
+
+// moderateSnippet applies action to the reported snippet, which also takes care of its open reports,
+// and records it in the audit log as auditAction
+func (app *application) moderateSnippet(w http.ResponseWriter, r *http.Request, action func(snippetID, moderatorID int) error, auditAction, flash string) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = action(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.audit(r, app.authenticatedUserID(r), auditAction, snippetTarget(id))
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf(flash, id))
+
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+func (app *application) moderationDismissPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.reports.Dismiss(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Report #%d has been dismissed", id))
+
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
 }
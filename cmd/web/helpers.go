@@ -2,22 +2,35 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"snippetbox.rakesh.net/internal/mailer"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/passcheck"
+	"snippetbox.rakesh.net/internal/validator"
+	"strings"
 	"time"
 )
 
 // Helper function to handle server errors
-func (app *application) serverError(w http.ResponseWriter, err error) {
-	// Capture the error message along with the stack trace
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-
-	// Log the error with the stack trace
-	app.errorLog.Output(2, trace)
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	// Log the error with the request it happened on and the stack trace, the request id
+	// matches the error to the access log and to the X-Request-ID the client was sent
+	app.logger.Error(err.Error(),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"request_id", requestID(r),
+		"trace", string(debug.Stack()),
+	)
 
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
@@ -32,12 +45,12 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("page '%s' not found in template cache", page)
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -48,7 +61,8 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	// earlier instead of http.ResponseWriter here we use buf
 	err := ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
 	}
 
 	// write out the provided HTTP status code
@@ -64,8 +78,21 @@ func (app *application) newTemplateData(r *http.Request) *templateData {
 		//add the flash message to the template data, if one exists
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
+		IsVerified:      app.isEmailVerified(r),
+		IsModerator:     models.HasRole(app.userRole(r), models.RoleModerator),
+		IsAdmin:         models.HasRole(app.userRole(r), models.RoleAdmin),
 		CSRFToken:       nosurf.Token(r),
+		LocalSignup:     app.localSignup,
+		SSOName:         app.ssoName(),
+	}
+}
+
+// ssoName returns the name of the single sign-on provider, or "" if there isn't one
+func (app *application) ssoName() string {
+	if app.oidc == nil {
+		return ""
 	}
+	return app.oidc.name
 }
 
 func (app *application) decodePostForm(r *http.Request, dst any) error {
@@ -92,3 +119,169 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// authenticatedUserID returns the id of the logged-in user or API client, or 0 if there is none
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+	return id
+}
+
+// writeJSON sends v as the JSON response body of the API handlers
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// apiError sends an error message in the same JSON envelope the API clients expect
+func (app *application) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.writeJSON(w, r, status, map[string]string{"error": message})
+}
+
+// snippetURL returns the absolute URL of a snippet's page
+func (app *application) snippetURL(id int) string {
+	return app.absoluteURL(fmt.Sprintf("/snippet/view/%d", id))
+}
+
+// isEmailVerified reports whether the logged-in user has verified their email address
+func (app *application) isEmailVerified(r *http.Request) bool {
+	verified, ok := r.Context().Value(emailVerifiedContextKey).(bool)
+	return ok && verified
+}
+
+// absoluteURL returns the full URL of path on this site, for links sent by email. It never
+// uses the Host header, which can be forged to send a user's link to someone else's site.
+func (app *application) absoluteURL(path string) string {
+	return app.baseURL + path
+}
+
+// sendMail sends msg in the background, so the response neither waits for the mail
+// server nor gives away whether an email was sent
+func (app *application) sendMail(msg mailer.Message) {
+	go func() {
+		if err := app.mailer.Send(msg); err != nil {
+			app.logger.Error("sending email failed", "to", msg.To, "error", err)
+		}
+	}()
+}
+
+// minimum passcheck score of new passwords
+const minPasswordScore = passcheck.ScoreSomewhatGuessable
+
+// maximum length of new passwords, the time passcheck takes grows with the square of the length
+const maxPasswordChars = 256
+
+// checkNewPassword checks a new password on signup or when it's changed, adding any
+// problem as an error on field. userInputs such as the user's name and email address
+// are the first things an attacker would try.
+func (app *application) checkNewPassword(v *validator.Validator, field, password string, userInputs ...string) error {
+	v.CheckField(validator.NotBlank(password), field, "This field cannot be blank")
+	v.CheckField(validator.MinChars(password, 8), field, "This field must be at least 8 characters long")
+	v.CheckField(validator.MaxChars(password, maxPasswordChars), field, fmt.Sprintf("This field cannot be more than %d characters", maxPasswordChars))
+	if v.FieldErrors[field] != "" {
+		return nil
+	}
+
+	if msg := passwordStrengthError(password, userInputs...); msg != "" {
+		v.AddFieldError(field, "This password is "+msg)
+		return nil
+	}
+
+	if app.breachedPasswords != nil {
+		breached, err := app.breachedPasswords.Contains(password)
+		if err != nil {
+			return err
+		}
+		v.CheckField(!breached, field, "This password has appeared in a data breach, please choose another")
+	}
+	return nil
+}
+
+// passwordStrengthError explains why password is too easy to guess, or returns "" if it's strong enough
+func passwordStrengthError(password string, userInputs ...string) string {
+	result := passcheck.Estimate(password, userInputs...)
+	if result.Score >= minPasswordScore {
+		return ""
+	}
+	if result.Warning == "" {
+		return "too easy to guess, try a longer one"
+	}
+	return "too easy to guess, " + result.Warning
+}
+
+// destroyUserSessions logs the user out of every session they have
+func (app *application) destroyUserSessions(ctx context.Context, userID int) error {
+	err := app.userSessions.DeleteForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	return app.sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if app.sessionManager.GetInt(ctx, "authenticatedID") == userID {
+			return app.sessionManager.Destroy(ctx)
+		}
+		return nil
+	})
+}
+
+// userRole returns the role of the logged-in user, or "" if there is none
+func (app *application) userRole(r *http.Request) string {
+	role, ok := r.Context().Value(userRoleContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return role
+}
+
+// clientIP returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isLocalPath reports whether path is on this site, so redirecting to it can't send the user elsewhere
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
+// requestID returns the id the requestID middleware gave the request, or "" outside of it
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// validRequestID reports whether an incoming X-Request-ID is short and plain enough to
+// log and send back as is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
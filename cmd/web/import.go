@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/secrets"
+	"sort"
+	"strings"
+)
+
+// maximum size of an uploaded or imported archive, which is also the most its files can
+// add up to once decompressed
+const maxImportSize = 32 << 20
+
+// maximum number of snippets in one import
+const maxImportItems = 5000
+
+var (
+	errUnknownImportFormat = errors.New("import: expected a zip archive or a JSON lines file")
+	errImportTooLarge      = errors.New("import: the archive is too large once decompressed")
+	errTooManyImportItems  = fmt.Errorf("import: there can be at most %d snippets in one import", maxImportItems)
+)
+
+// importItem is a single snippet read from an import file, Source tells the user where it came from
+type importItem struct {
+	Source string
+	Form   snippetCreateForm
+}
+
+// importLine is the format of each line in a JSON lines import, expires is in days
+type importLine struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	Expires int    `json:"expires"`
+}
+
+// importResult reports the outcome of importing a single item
+type importResult struct {
+	Source string
+	Title  string
+	ID     int
+	Errors []string
+}
+
+func (res importResult) OK() bool {
+	return len(res.Errors) == 0
+}
+
+// readImport parses the contents of an import file, which is either a zip
+// archive in the layout written by the export or a JSON lines file
+func readImport(data []byte) ([]importItem, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readImportArchive(data)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, errUnknownImportFormat
+	}
+	return readImportLines(trimmed)
+}
+
+func readImportArchive(data []byte) ([]importItem, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+
+	//the sizes in the zip headers can't be trusted, so every file is read through a limit
+	//and the total is counted, a manifest can also list the same file many times
+	remaining := int64(maxImportSize)
+
+	var manifest exportManifest
+	if err := readArchiveFile(zr, manifestName, func(r io.Reader) error {
+		lr := &io.LimitedReader{R: r, N: remaining}
+		err := json.NewDecoder(lr).Decode(&manifest)
+		remaining = lr.N
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if len(manifest.Snippets) > maxImportItems {
+		return nil, errTooManyImportItems
+	}
+
+	items := make([]importItem, 0, len(manifest.Snippets))
+
+	for _, s := range manifest.Snippets {
+		var content strings.Builder
+		if err := readArchiveFile(zr, s.File, func(r io.Reader) error {
+			//one byte over the limit is enough for validate to reject the item
+			n, err := io.Copy(&content, io.LimitReader(r, maxSnippetContentSize+1))
+			remaining -= n
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		if remaining < 0 {
+			return nil, errImportTooLarge
+		}
+
+		items = append(items, importItem{
+			Source: s.File,
+			Form: snippetCreateForm{
+				Title:   s.Title,
+				Content: content.String(),
+				//the original lifetime in days, which is checked against the permitted values like any other item
+				Expires: int(math.Round(s.Expires.Sub(s.Created).Hours() / 24)),
+			},
+		})
+	}
+	return items, nil
+}
+
+func readArchiveFile(zr *zip.Reader, name string, fn func(io.Reader) error) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	defer f.Close()
+
+	if err := fn(f); err != nil {
+		return fmt.Errorf("import: reading %s: %w", name, err)
+	}
+	return nil
+}
+
+func readImportLines(data []byte) ([]importItem, error) {
+	items := []importItem{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxImportSize)
+
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if len(items) == maxImportItems {
+			return nil, errTooManyImportItems
+		}
+
+		var l importLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			return nil, fmt.Errorf("import: line %d: %w", n, err)
+		}
+
+		items = append(items, importItem{
+			Source: fmt.Sprintf("line %d", n),
+			Form: snippetCreateForm{
+				Title:   l.Title,
+				Content: l.Content,
+				Expires: l.Expires,
+			},
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+	return items, nil
+}
+
+// importSnippets validates every item with the same rules as the create form
+// and inserts the valid ones for the user in a single transaction. Imports can't
+// confirm secrets like the create form can, so items with secrets fail.
+func (app *application) importSnippets(items []importItem, userID int) ([]importResult, error) {
+	results := make([]importResult, len(items))
+	valid := []models.NewSnippet{}
+	validIdx := []int{}
+
+	for i := range items {
+		form := items[i].Form
+		form.validate()
+		if findings := secrets.Scan(form.Content); len(findings) > 0 {
+			form.AddFieldError("content", fmt.Sprintf("This looks like it contains secrets (%s), remove them or create the snippet on its own", describeFindings(findings)))
+		}
+
+		results[i] = importResult{Source: items[i].Source, Title: form.Title}
+
+		if !form.Valid() {
+			keys := make([]string, 0, len(form.FieldErrors))
+			for key := range form.FieldErrors {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				results[i].Errors = append(results[i].Errors, fmt.Sprintf("%s: %s", key, form.FieldErrors[key]))
+			}
+			continue
+		}
+
+		valid = append(valid, models.NewSnippet{Title: form.Title, Content: form.Content, Expires: form.Expires})
+		validIdx = append(validIdx, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	ids, err := app.snippets.InsertAll(valid, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, id := range ids {
+		results[validIdx[j]].ID = id
+	}
+	return results, nil
+}
+
+// runImport implements the "web import" subcommand, e.g.
+//
+//	web import -user alice@example.com snippets.zip
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dsn := flags.String("dsn", defaultDSN, "MySQL datasource name")
+	email := flags.String("user", "", "email address of the user who will own the imported snippets")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: web import [-dsn dsn] -user email file")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *email == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fatal(logger, err.Error())
+	}
+
+	items, err := readImport(content)
+	if err != nil {
+		fatal(logger, err.Error())
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fatal(logger, err.Error())
+	}
+	defer db.Close()
+
+	app := &application{
+		logger:   logger,
+		snippets: &models.SnippetModel{DB: db},
+		users:    &models.UserModel{DB: db},
+	}
+
+	userID, err := app.users.IDByEmail(*email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			fatal(logger, "no user with that email", "email", *email)
+		}
+		fatal(logger, err.Error())
+	}
+
+	results, err := app.importSnippets(items, userID)
+	if err != nil {
+		fatal(logger, err.Error())
+	}
+
+	failed := 0
+	for _, res := range results {
+		if res.OK() {
+			fmt.Printf("ok\t%s\t#%d\t%s\n", res.Source, res.ID, res.Title)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL\t%s\t%s\t%s\n", res.Source, res.Title, strings.Join(res.Errors, "; "))
+	}
+
+	logger.Info("imported snippets", "imported", len(results)-failed, "total", len(results))
+	if failed > 0 {
+		db.Close()
+		os.Exit(1)
+	}
+}
@@ -1,72 +1,239 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"encoding/base64"
 	"flag"
+	"fmt"
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"snippetbox.rakesh.net/internal/mailer"
 	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/passcheck"
+	"snippetbox.rakesh.net/internal/ratelimit"
+	"strings"
 	"time"
 )
 
-// application struct holds loggers for error and info logging.
+// application struct holds the logger and the dependencies shared by the handlers.
 type application struct {
-	errorLog       *log.Logger
-	infoLog        *log.Logger
+	logger         *slog.Logger
 	snippets       *models.SnippetModel
 	users          *models.UserModel
+	webhooks       *models.WebhookModel
+	apiTokens      *models.APITokenModel
+	reports        *models.ReportModel
+	loginThrottle  *models.LoginThrottleModel
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	broadcaster    *snippetBroadcaster
+	limiter        ratelimit.Limiter
+	passwordResets *models.PasswordResetModel
+	twoFactor      *models.TwoFactorModel
+	passkeys       *models.PasskeyModel
+	webAuthn       *webauthn.WebAuthn
+	identities     *models.IdentityModel
+	userSessions   *models.UserSessionModel
+	auditEvents    *models.AuditModel
+	mailer         mailer.Mailer
+	baseURL        string
+	signingKey     []byte
+	//whether users who haven't verified their email address can log in, they can't create snippets either way
+	unverifiedLogin bool
+	//whether people can sign up with a password rather than only through single sign-on
+	localSignup bool
+	//nil unless single sign-on is configured
+	oidc *oidcProvider
+	//lifetime of sessions where the user ticked "remember me"
+	rememberLifetime time.Duration
+	//nil unless a breached password list is configured
+	breachedPasswords *passcheck.BreachList
 }
 
+// default MySQL datasource name, shared by the server and the subcommands
+const defaultDSN = "web:pass@/snippetbox?parseTime=true"
+
+// writeTimeout is also used by long-lived responses, which extend their write deadline by this much at a time
+const writeTimeout = 10 * time.Second
+
 func main() {
+	//subcommands are dispatched before the server flags are parsed
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "admin":
+			runAdmin(os.Args[2:])
+			return
+		}
+	}
+
 	addr := flag.String("addr", ":4000", "http service address")
-	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL datasource name")
+	dsn := flag.String("dsn", defaultDSN, "MySQL datasource name")
+	baseURL := flag.String("base-url", "", "public URL of the site used in emailed links and by passkeys, e.g. https://snippetbox.example.com or https://localhost:4000 (required)")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server host:port, email is written to the log when empty")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailFrom := flag.String("mail-from", "no-reply@snippetbox.local", "sender address of outgoing email")
+	signingKey := flag.String("signing-key", "", "base64 key of at least 32 bytes that signs emailed links, one is generated and kept in the database when empty")
+	unverifiedLogin := flag.Bool("unverified-login", true, "let users log in before verifying their email address")
+	oidcIssuer := flag.String("oidc-issuer", "", "issuer URL of an OpenID Connect provider for single sign-on")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	oidcName := flag.String("oidc-name", "single sign-on", "name of the identity provider shown on the login page")
+	localSignup := flag.Bool("local-signup", true, "let people sign up with an email address and password")
+	rememberLifetime := flag.Duration("remember-lifetime", 30*24*time.Hour, "how long a \"remember me\" login lasts, other logins last 12 hours")
+	idleTimeout := flag.Duration("idle-timeout", 7*24*time.Hour, "how long a session can go unused before it expires, 0 for no limit")
+	breachedPasswords := flag.String("breached-passwords", "", "directory of SHA-1 hash prefix files of breached passwords, in the Have I Been Pwned range format")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	rateLimitStore := flag.String("ratelimit", "memory", "where rate limit buckets are kept: memory, or mysql to share them between instances")
 
 	flag.Parse()
 
-	// Create loggers for info and error messages.
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Llongfile)
+	//structured logs, as text for people or JSON for log collectors
+	logger, err := newLogger(*logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	db, err := openDB(*dsn)
 	if err != nil {
-		errorLog.Fatal(err)
+		fatal(logger, err.Error())
 	}
 	defer db.Close()
 
 	//initialize a new template cache...
 	templateCache, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err)
+		fatal(logger, err.Error())
 	}
 
 	//initialize a decoder instance
 	formDecoder := form.NewDecoder()
 
-	//use sessions and set a time limit of 12hrs
+	//use sessions and set a time limit of 12hrs, "remember me" logins extend their own deadline and
+	//get a persistent cookie. The idle timeout applies to every session, but only cuts short the long ones
+	//unless it's under 12 hours.
 	sessionManager := scs.New()
 	sessionManager.Store = mysqlstore.New(db)
 	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.IdleTimeout = *idleTimeout
+	sessionManager.Cookie.Persist = false
 	sessionManager.Cookie.Secure = true
 
+	//the in-memory limiter is enough for a single instance
+	var limiter ratelimit.Limiter
+	switch *rateLimitStore {
+	case "memory":
+		limiter = ratelimit.NewMemoryLimiter()
+	case "mysql":
+		mysqlLimiter := &ratelimit.MySQLLimiter{DB: db}
+		go func() {
+			for range time.Tick(10 * time.Minute) {
+				if err := mysqlLimiter.DeleteIdle(24 * time.Hour); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+		}()
+		limiter = mysqlLimiter
+	default:
+		fatal(logger, "unknown -ratelimit store", "store", *rateLimitStore)
+	}
+
+	//every instance has to sign links with the same key, and keep it across restarts, for
+	//links to keep working. Without a key on the command line, one is kept in the database.
+	key, err := base64.StdEncoding.DecodeString(*signingKey)
+	if err != nil || (len(key) > 0 && len(key) < 32) {
+		fatal(logger, "-signing-key must be at least 32 bytes of base64")
+	}
+	if len(key) == 0 {
+		key, err = (&models.SigningKeyModel{DB: db}).Get("links", 32)
+		if err != nil {
+			fatal(logger, err.Error())
+		}
+	}
+
+	//emailed links are built from the public URL rather than the Host header, which anyone can
+	//forge to have a victim's reset link sent to their own site. Passkeys are bound to its host name too.
+	origin := strings.TrimSuffix(*baseURL, "/")
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Scheme == "" || originURL.Host == "" {
+		fatal(logger, "-base-url must be the public URL of the site, e.g. https://localhost:4000")
+	}
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          originURL.Hostname(),
+		RPDisplayName: "Snippetbox",
+		RPOrigins:     []string{origin},
+	})
+	if err != nil {
+		fatal(logger, err.Error())
+	}
+
+	var sso *oidcProvider
+	if *oidcIssuer != "" {
+		redirectURL := origin + "/user/login/oidc/callback"
+		sso, err = newOIDCProvider(context.Background(), *oidcName, *oidcIssuer, *oidcClientID, *oidcClientSecret, redirectURL)
+		if err != nil {
+			fatal(logger, err.Error())
+		}
+	}
+
+	//fail at startup rather than on the first signup if the directory is wrong
+	var breached *passcheck.BreachList
+	if *breachedPasswords != "" {
+		if info, err := os.Stat(*breachedPasswords); err != nil || !info.IsDir() {
+			fatal(logger, "-breached-passwords is not a directory", "dir", *breachedPasswords)
+		}
+		breached = &passcheck.BreachList{Dir: *breachedPasswords}
+	}
+
+	var m mailer.Mailer = &mailer.LogMailer{Log: logger, From: *mailFrom}
+	if *smtpAddr != "" {
+		m = &mailer.SMTPMailer{Addr: *smtpAddr, Username: *smtpUsername, Password: *smtpPassword, From: *mailFrom}
+	}
+
 	// Initialize the application with the loggers
 	app := &application{
-		errorLog:       errorLog,
-		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		logger:            logger,
+		snippets:          &models.SnippetModel{DB: db},
+		users:             &models.UserModel{DB: db},
+		webhooks:          &models.WebhookModel{DB: db},
+		apiTokens:         &models.APITokenModel{DB: db},
+		reports:           &models.ReportModel{DB: db},
+		loginThrottle:     &models.LoginThrottleModel{DB: db},
+		templateCache:     templateCache,
+		formDecoder:       formDecoder,
+		sessionManager:    sessionManager,
+		rememberLifetime:  *rememberLifetime,
+		broadcaster:       newSnippetBroadcaster(),
+		limiter:           limiter,
+		passwordResets:    &models.PasswordResetModel{DB: db},
+		twoFactor:         &models.TwoFactorModel{DB: db},
+		passkeys:          &models.PasskeyModel{DB: db},
+		webAuthn:          webAuthn,
+		identities:        &models.IdentityModel{DB: db},
+		userSessions:      &models.UserSessionModel{DB: db},
+		auditEvents:       &models.AuditModel{DB: db},
+		oidc:              sso,
+		localSignup:       *localSignup,
+		mailer:            m,
+		baseURL:           origin,
+		signingKey:        key,
+		unverifiedLogin:   *unverifiedLogin,
+		breachedPasswords: breached,
 	}
 
 	//tls config of only elliptical curves with assembly implementations are used
@@ -76,23 +243,56 @@ func main() {
 
 	// Create a new HTTP server with specific address, error log, and handler (routes)
 	srv := &http.Server{
-		Addr:      *addr,        // The address to listen on (default ":4000")
-		ErrorLog:  errorLog,     // Error log for server
-		Handler:   app.routes(), // The HTTP request handler (defined in routes.go)
+		Addr:      *addr,                                                // The address to listen on (default ":4000")
+		ErrorLog:  slog.NewLogLogger(logger.Handler(), slog.LevelError), // Error log for server
+		Handler:   app.routes(),                                         // The HTTP request handler (defined in routes.go)
 		TLSConfig: tlsConfig,
 		//adding idle,read and write timeouts to the server
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 	}
 
+	//send queued webhook deliveries in the background
+	go app.dispatchWebhooks()
+
+	//forget old failed logins from client addresses, and sessions that have expired
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := app.loginThrottle.DeleteStale(); err != nil {
+				logger.Error(err.Error())
+			}
+			if err := app.userSessions.DeleteExpired(); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}()
+
 	// Log server startup message
-	infoLog.Printf("Starting server on %s", *addr)
+	logger.Info("starting server", "addr", *addr)
 
 	//use the ListenAndServeTLS method to start https server(http + tls[transport layer security]).
 	//we pass in the paths to the TLS certificate and correstpoding private key as the two params
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err) // If there's an error starting the server, log it and exit.
+	fatal(logger, err.Error()) // If there's an error starting the server, log it and exit.
+}
+
+// newLogger returns a logger writing to standard output in the given format, text or json
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown -log-format %q", format)
+	}
+}
+
+// fatal logs an error that stops the program from starting, and exits
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
+	"math"
 	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/ratelimit"
+	"strconv"
+	"strings"
 )
 
 func secureHeaders(next http.Handler) http.Handler {
@@ -43,16 +50,45 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// requestID gives every request an id, taken from the X-Request-ID header when a proxy
+// in front has already set a sensible one. The id goes in the request context for the
+// logs and back in the response headers, so a user's report can be matched to the logs.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	// Return an HTTP handler function that wraps the next handler
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Log the details of the incoming request using the application's info logger.
-		// The log format includes:
-		// - `r.RemoteAddr`: The client's IP address.
-		// - `r.Proto`: The protocol used (e.g., HTTP/1.1).
-		// - `r.Method`: The HTTP method (e.g., GET, POST).
-		// - `r.URL.RequestURI()`: The requested URI (path and query string).
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		// Log the details of the incoming request using the application's logger.
+		// The attributes are:
+		// - `ip`: The client's address.
+		// - `proto`: The protocol used (e.g., HTTP/1.1).
+		// - `method`: The HTTP method (e.g., GET, POST).
+		// - `uri`: The requested URI (path and query string).
+		// - `request_id`: The id set by the requestID middleware, which errors are logged with too.
+		app.logger.Info("received request",
+			"ip", r.RemoteAddr,
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"request_id", requestID(r),
+		)
 
 		// Call the next handler in the middleware chain, passing along the response writer and request.
 		next.ServeHTTP(w, r)
@@ -71,7 +107,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 
 				// Log the panic as a server error for debugging purposes.
 				// The panic value (`err`) is converted to an `error` type using `fmt.Errorf`.
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 
@@ -94,6 +130,39 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireRole returns middleware that only lets through users with at least the given role, e.g.
+//
+//	admin := protected.Append(app.requireRole(models.RoleAdmin))
+func (app *application) requireRole(role string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.isAuthenticated(r) {
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			if !models.HasRole(app.userRole(r), role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			w.Header().Add("Cache-Control", "no-cache")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// maxBytes limits the size of request bodies to n bytes, reading past that fails
+func maxBytes(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -107,24 +176,136 @@ func noSurf(next http.Handler) http.Handler {
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//retrieve the authenticatedUserID value from the session using the GetInt() method
-		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		id := app.sessionManager.GetInt(r.Context(), "authenticatedID")
 		if id == 0 {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		exists, err := app.users.Exists(id)
+		user, err := app.users.Get(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		if err != nil || user.Disabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//sessions that have been revoked, or were started before sessions were recorded, are logged out
+		sessionID := app.sessionManager.GetString(r.Context(), "userSessionID")
+		err = app.userSessions.Seen(sessionID, id, clientIP(r), r.UserAgent())
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			app.sessionManager.Remove(r.Context(), "authenticatedID")
+			app.sessionManager.Remove(r.Context(), "userSessionID")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//a matching, enabled user is found, create a new copy of the request
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
+		ctx = context.WithValue(ctx, userRoleContextKey, user.Role)
+		ctx = context.WithValue(ctx, emailVerifiedContextKey, user.EmailVerified)
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireVerifiedEmail sends users who haven't verified their email address to the page that resends the link.
+// It goes after requireAuthentication.
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isEmailVerified(r) {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address before creating snippets")
+			http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiAuthenticate authenticates API requests using the bearer token in the Authorization header,
+// requests without one carry on anonymously
+func (app *application) apiAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			app.invalidAPIToken(w, r)
+			return
+		}
+
+		id, err := app.apiTokens.Authenticate(token)
 		if err != nil {
-			app.serverError(w, err)
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.invalidAPIToken(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
 			return
 		}
 
-		//if matching user is foun, create a new copy of the request
-		if exists {
-			ctx := context.WithValue(r.Context(), "isAuthenticatedContextKey", true)
-			r = r.WithContext(ctx)
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAPIToken is the API counterpart of requireAuthentication
+func (app *application) requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			app.invalidAPIToken(w, r)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *application) invalidAPIToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	app.apiError(w, r, http.StatusUnauthorized, "invalid or missing API token")
+}
+
+// rateLimit returns middleware that limits requests to the route group to rate, with
+// separate buckets per client IP and, when someone is logged in, per user
+func (app *application) rateLimit(group string, rate ratelimit.Rate) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys := []string{fmt.Sprintf("%s:ip:%s", group, clientIP(r))}
+			if id := app.authenticatedUserID(r); id != 0 {
+				keys = append(keys, fmt.Sprintf("%s:user:%d", group, id))
+			}
+
+			for _, key := range keys {
+				allowed, retryAfter, err := app.limiter.Allow(key, rate)
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+
+				if !allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					app.clientError(w, http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"strings"
+)
+
+var (
+	errSSONoEmail            = errors.New("the identity provider didn't share an email address")
+	errSSOEmailNotVerified   = errors.New("an account already uses this email address, log in with your password instead")
+	errSSOAccountNotVerified = errors.New("an account already uses this email address but hasn't verified it, log in with your password and verify it first")
+)
+
+// oidcProvider is the OpenID Connect identity provider used for single sign-on
+type oidcProvider struct {
+	// name is shown on the login button
+	name     string
+	issuer   string
+	verifier *oidc.IDTokenVerifier
+	config   oauth2.Config
+}
+
+// oidcClaims are the claims read from ID tokens
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// newOIDCProvider discovers the provider's endpoints and keys from its issuer URL
+func newOIDCProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string) (*oidcProvider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcProvider{
+		name:     name,
+		issuer:   issuer,
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oidcLogin sends the user to the identity provider, remembering what's needed to check the response
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+	if next := r.URL.Query().Get("next"); isLocalPath(next) {
+		app.sessionManager.Put(r.Context(), "loginRedirect", next)
+	}
+
+	url := app.oidc.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcCallback is where the identity provider sends the user back with an authorization code
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	query := r.URL.Query()
+	if state == "" || query.Get("state") != state {
+		app.ssoFailed(w, r, "Your single sign-on login timed out, Please try again")
+		return
+	}
+	if query.Get("error") != "" {
+		app.ssoFailed(w, r, "Single sign-on was cancelled or refused")
+		return
+	}
+
+	token, err := app.oidc.config.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.logger.Error("oidc: exchanging code failed", "request_id", requestID(r), "error", err)
+		app.ssoFailed(w, r, "Single sign-on failed, Please try again")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.logger.Error("oidc: token response has no id_token", "request_id", requestID(r))
+		app.ssoFailed(w, r, "Single sign-on failed, Please try again")
+		return
+	}
+
+	idToken, err := app.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		app.logger.Error("oidc: invalid id token", "request_id", requestID(r), "error", err)
+		app.ssoFailed(w, r, "Single sign-on failed, Please try again")
+		return
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	id, err := app.oidcUser(claims)
+	if err != nil {
+		if errors.Is(err, errSSONoEmail) || errors.Is(err, errSSOEmailNotVerified) || errors.Is(err, errSSOAccountNotVerified) {
+			app.ssoFailed(w, r, "Single sign-on failed, "+err.Error())
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.Disabled {
+		app.ssoFailed(w, r, "This account has been disabled")
+		return
+	}
+	if !app.unverifiedLogin && !user.EmailVerified {
+		app.ssoFailed(w, r, "Please verify your email address before logging in")
+		return
+	}
+
+	//the identity provider is responsible for any second factor
+	app.completeLogin(w, r, id, false)
+}
+
+// oidcUser returns the id of the user for the claims of an ID token. Users are found by
+// subject, then linked by email address if both the provider and the account have verified
+// it, and otherwise created on the spot.
+func (app *application) oidcUser(claims oidcClaims) (int, error) {
+	id, err := app.identities.UserID(app.oidc.issuer, claims.Subject)
+	if err == nil || !errors.Is(err, models.ErrNoRecord) {
+		return id, err
+	}
+
+	if claims.Email == "" {
+		return 0, errSSONoEmail
+	}
+
+	id, err = app.users.IDByEmail(claims.Email)
+	if err == nil {
+		//linking on an address the provider hasn't checked would let anyone take over the account
+		if !claims.EmailVerified {
+			return 0, errSSOEmailNotVerified
+		}
+
+		//nor on an account whose owner hasn't proven the address, it may have been registered
+		//by someone waiting for the real owner to sign in and keep using their password
+		user, err := app.users.Get(id)
+		if err != nil {
+			return 0, err
+		}
+		if !user.EmailVerified {
+			return 0, errSSOAccountNotVerified
+		}
+
+		err = app.identities.Link(app.oidc.issuer, claims.Subject, id)
+		return id, err
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return 0, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	id, err = app.identities.Provision(app.oidc.issuer, claims.Subject, name, claims.Email, claims.EmailVerified)
+	if errors.Is(err, models.ErrDuplicateEmail) {
+		//someone signed up with the address in the meantime
+		return 0, errSSOEmailNotVerified
+	}
+	return id, err
+}
+
+func (app *application) ssoFailed(w http.ResponseWriter, r *http.Request, message string) {
+	app.sessionManager.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"snippetbox.rakesh.net/internal/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testOIDCClientID     = "snippetbox"
+	testOIDCClientSecret = "client-secret"
+)
+
+// testOIDCProvider is an OpenID Connect provider with discovery, a JWKS and a token
+// endpoint that checks PKCE. Logins are started with authorize instead of a login page.
+type testOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// authorization codes that haven't been exchanged yet
+	codes map[string]testOIDCCode
+}
+
+// testOIDCCode is what the provider remembers about an authorization code until it's exchanged
+type testOIDCCode struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testOIDCProvider{key: key, codes: map[string]testOIDCCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("POST /token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testOIDCProvider) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *testOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	p.writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *testOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   b64.EncodeToString(p.key.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// token exchanges an authorization code for an ID token, if the code verifier matches its challenge
+func (p *testOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != testOIDCClientID || clientSecret != testOIDCClientSecret {
+		p.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	code, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || b64.EncodeToString(verifier[:]) != code.challenge {
+		p.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.idToken(code.claims),
+	})
+}
+
+// idToken signs claims as an RS256 JWT from this provider for the test client
+func (p *testOIDCProvider) idToken(claims map[string]any) string {
+	payload := map[string]any{
+		"iss": p.URL,
+		"aud": testOIDCClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		payload[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	body, _ := json.Marshal(payload)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+// authorize plays the part of the provider's login page for the authorization URL the
+// application redirected to, with the user vouched for by claims. It returns the query
+// string the provider would send the browser back to the callback with.
+func (p *testOIDCProvider) authorize(t *testing.T, authURL string, claims map[string]any) url.Values {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+
+	if !strings.HasPrefix(authURL, p.URL+"/authorize?") || q.Get("client_id") != testOIDCClientID || q.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization URL %q", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL %q doesn't use PKCE", authURL)
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization URL %q has no state or nonce", authURL)
+	}
+
+	//the nonce is echoed back unless the claims say otherwise
+	withNonce := map[string]any{"nonce": q.Get("nonce")}
+	for k, v := range claims {
+		withNonce[k] = v
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	p.mu.Lock()
+	p.codes[code] = testOIDCCode{challenge: q.Get("code_challenge"), claims: withNonce}
+	p.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+// newTestSSOServer starts the application with single sign-on through a test provider
+func newTestSSOServer(t *testing.T) (*application, *testServer, *testOIDCProvider) {
+	provider := newTestOIDCProvider(t)
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	sso, err := newOIDCProvider(context.Background(), "Example", provider.URL, testOIDCClientID, testOIDCClientSecret, ts.URL+"/user/login/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.oidc = sso
+	return app, ts, provider
+}
+
+// startSSOLogin starts a single sign-on login in the browser and returns the authorization URL
+func startSSOLogin(t *testing.T, ts *testServer) string {
+	status, header, _ := ts.get(t, "/user/login/oidc")
+	if status != http.StatusFound {
+		t.Fatalf("starting single sign-on: got status %d, want %d", status, http.StatusFound)
+	}
+	return header.Get("Location")
+}
+
+// finishSSOLogin returns the browser to the callback with query, and returns where the callback redirected to
+func finishSSOLogin(t *testing.T, ts *testServer, query url.Values) string {
+	status, header, _ := ts.get(t, "/user/login/oidc/callback?"+query.Encode())
+	if status != http.StatusSeeOther {
+		t.Fatalf("single sign-on callback: got status %d, want %d", status, http.StatusSeeOther)
+	}
+	return header.Get("Location")
+}
+
+// ssoLogin logs in through the provider in a new browser, as the user the claims describe.
+// It returns where the callback redirected to, /snippet/create on success.
+func ssoLogin(t *testing.T, ts *testServer, provider *testOIDCProvider, claims map[string]any) string {
+	ts.resetClient(t)
+	return finishSSOLogin(t, ts, provider.authorize(t, startSSOLogin(t, ts), claims))
+}
+
+func TestOIDCLoginChecks(t *testing.T) {
+	_, ts, provider := newTestSSOServer(t)
+
+	claims := func(sub string) map[string]any {
+		return map[string]any{"sub": sub, "email": sub + "@example.com", "email_verified": true, "name": "Alice"}
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		if got := ssoLogin(t, ts, provider, claims("valid")); got != "/snippet/create" {
+			t.Errorf("got redirect to %q, want %q", got, "/snippet/create")
+		}
+	})
+
+	t.Run("Wrong state", func(t *testing.T) {
+		ts.resetClient(t)
+		query := provider.authorize(t, startSSOLogin(t, ts), claims("state"))
+		query.Set("state", "forged")
+		if got := finishSSOLogin(t, ts, query); got != "/user/login" {
+			t.Errorf("got redirect to %q, want %q", got, "/user/login")
+		}
+	})
+
+	t.Run("Wrong nonce", func(t *testing.T) {
+		c := claims("nonce")
+		c["nonce"] = "replayed"
+		if got := ssoLogin(t, ts, provider, c); got != "/user/login" {
+			t.Errorf("got redirect to %q, want %q", got, "/user/login")
+		}
+	})
+
+	t.Run("Code from another login", func(t *testing.T) {
+		//the code was issued for the first login's PKCE challenge, but the browser now holds the second login's verifier
+		ts.resetClient(t)
+		first := provider.authorize(t, startSSOLogin(t, ts), claims("pkce"))
+		second := provider.authorize(t, startSSOLogin(t, ts), claims("pkce"))
+
+		query := url.Values{"code": {first.Get("code")}, "state": {second.Get("state")}}
+		if got := finishSSOLogin(t, ts, query); got != "/user/login" {
+			t.Errorf("got redirect to %q, want %q", got, "/user/login")
+		}
+	})
+}
+
+func TestSSOLoginRedirectsToNext(t *testing.T) {
+	_, ts, provider := newTestSSOServer(t)
+
+	tests := []struct {
+		name string
+		next string
+		want string
+	}{
+		{"Local path", "/user/account", "/user/account"},
+		{"Other site", "//example.com/user/account", "/snippet/create"},
+		{"Backslash", "/\\example.com", "/snippet/create"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.resetClient(t)
+			status, header, _ := ts.get(t, "/user/login/oidc?next="+url.QueryEscape(tt.next))
+			if status != http.StatusFound {
+				t.Fatalf("got status %d, want %d", status, http.StatusFound)
+			}
+
+			claims := map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+			got := finishSSOLogin(t, ts, provider.authorize(t, header.Get("Location"), claims))
+			if got != tt.want {
+				t.Errorf("got redirect to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginProvisionsBySubject(t *testing.T) {
+	app, ts, provider := newTestSSOServer(t)
+
+	claims := map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true, "name": "Alice"}
+	if got := ssoLogin(t, ts, provider, claims); got != "/snippet/create" {
+		t.Fatalf("first login: got redirect to %q, want %q", got, "/snippet/create")
+	}
+
+	id, err := app.identities.UserID(provider.URL, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := app.users.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.Name != "Alice" || !user.EmailVerified {
+		t.Errorf("got provisioned user %+v", user)
+	}
+	hasPassword, err := app.users.HasPassword(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasPassword {
+		t.Error("provisioned user has a password")
+	}
+
+	//the subject identifies the user, even once their address at the provider changes
+	claims["email"] = "alice@new.example.com"
+	if got := ssoLogin(t, ts, provider, claims); got != "/snippet/create" {
+		t.Fatalf("second login: got redirect to %q, want %q", got, "/snippet/create")
+	}
+	_, err = app.users.IDByEmail("alice@new.example.com")
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("second login created another user, IDByEmail returned %v", err)
+	}
+}
+
+func TestOIDCLoginLinksByEmail(t *testing.T) {
+	tests := []struct {
+		name             string
+		localVerified    bool
+		providerVerified bool
+		wantRedirect     string
+		wantLinked       bool
+	}{
+		{"Both verified", true, true, "/snippet/create", true},
+		{"Unverified at the provider", true, false, "/user/login", false},
+		{"Unverified account", false, true, "/user/login", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, ts, provider := newTestSSOServer(t)
+
+			id, err := app.users.Insert("Alice", "alice@example.com", "correct horse battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.localVerified {
+				if err := app.users.VerifyEmail(id, "alice@example.com"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			claims := map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": tt.providerVerified}
+			if got := ssoLogin(t, ts, provider, claims); got != tt.wantRedirect {
+				t.Errorf("got redirect to %q, want %q", got, tt.wantRedirect)
+			}
+
+			linked, err := app.identities.UserID(provider.URL, "alice")
+			switch {
+			case tt.wantLinked && (err != nil || linked != id):
+				t.Errorf("identity linked to user %d (%v), want %d", linked, err, id)
+			case !tt.wantLinked && !errors.Is(err, models.ErrNoRecord):
+				t.Errorf("identity linked to user %d (%v), want no link", linked, err)
+			}
+		})
+	}
+}
+
+func TestOIDCLocalSignupDisabled(t *testing.T) {
+	app, ts, provider := newTestSSOServer(t)
+	app.localSignup = false
+
+	status, header, _ := ts.get(t, "/user/signup")
+	if status != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+		t.Errorf("signup page: got status %d to %q, want a redirect to the login page", status, header.Get("Location"))
+	}
+
+	_, _, page := ts.get(t, "/user/login")
+	status, _, _ = ts.postForm(t, "/user/signup", url.Values{
+		"name":       {"Bob"},
+		"email":      {"bob@example.com"},
+		"password":   {"correct horse battery"},
+		"csrf_token": {extractCSRFToken(t, page)},
+	})
+	if status != http.StatusSeeOther {
+		t.Errorf("signup form: got status %d, want %d", status, http.StatusSeeOther)
+	}
+	if _, err := app.users.IDByEmail("bob@example.com"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("signup form created a user, IDByEmail returned %v", err)
+	}
+
+	//people can still sign up through single sign-on
+	claims := map[string]any{"sub": "carol", "email": "carol@example.com", "email_verified": true}
+	if got := ssoLogin(t, ts, provider, claims); got != "/snippet/create" {
+		t.Errorf("single sign-on: got redirect to %q, want %q", got, "/snippet/create")
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/validator"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// passkeyRegisterInput is the body of passkeyRegisterBegin
+type passkeyRegisterInput struct {
+	// Password is the user's current password, users without one must have just logged in instead
+	Password string `json:"password"`
+}
+
+// webAuthnUser adapts a user and their passkeys to the webauthn library
+type webAuthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID is the user handle, which passkeys hand back on login so the user can be found without an email address
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.Itoa(u.user.ID))
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// loadWebAuthnUser loads the user with the given id along with their passkeys
+func (app *application) loadWebAuthnUser(id int) (*webAuthnUser, error) {
+	user, err := app.users.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := app.passkeys.ForUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &webAuthnUser{user: user}
+	for _, p := range passkeys {
+		var c webauthn.Credential
+		if err := json.Unmarshal(p.Credential, &c); err != nil {
+			return nil, err
+		}
+		//the column is what gets checked and updated atomically, so it wins
+		c.Authenticator.SignCount = p.SignCount
+		u.credentials = append(u.credentials, c)
+	}
+	return u, nil
+}
+
+// putWebAuthnSession keeps the challenge of a ceremony in the session until the browser responds
+func (app *application) putWebAuthnSession(r *http.Request, key string, session *webauthn.SessionData) error {
+	js, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), key, js)
+	return nil
+}
+
+// popWebAuthnSession removes and returns the challenge stored by putWebAuthnSession, so it can only be answered once
+func (app *application) popWebAuthnSession(r *http.Request, key string) (webauthn.SessionData, bool) {
+	var session webauthn.SessionData
+
+	js := app.sessionManager.PopBytes(r.Context(), key)
+	if js == nil || json.Unmarshal(js, &session) != nil {
+		return session, false
+	}
+	return session, true
+}
+
+func (app *application) passkeyList(w http.ResponseWriter, r *http.Request) {
+	passkeys, err := app.passkeys.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	hasPassword, err := app.users.HasPassword(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Passkeys = passkeys
+	data.HasPassword = hasPassword
+	app.render(w, r, http.StatusOK, "passkeys.tmpl", data)
+}
+
+// passkeyRegisterBegin starts the registration ceremony, answering with the options for navigator.credentials.create
+func (app *application) passkeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	var input passkeyRegisterInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.apiError(w, r, http.StatusBadRequest, "request body must be a JSON object with the current password")
+		return
+	}
+
+	//a stolen session on its own mustn't be enough to add a way into the account
+	var v validator.Validator
+	app.checkCurrentPassword(&v, r, app.authenticatedUserID(r), input.Password, "password")
+	if !v.Valid() {
+		app.apiError(w, r, http.StatusUnprocessableEntity, v.FieldErrors["password"])
+		return
+	}
+
+	u, err := app.loadWebAuthnUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	//don't register the same authenticator twice
+	exclude := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		exclude = append(exclude, c.Descriptor())
+	}
+
+	options, session, err := app.webAuthn.BeginRegistration(u,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclude),
+	)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.putWebAuthnSession(r, "webauthnRegistration", session)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, options)
+}
+
+// passkeyRegisterFinish checks the new credential created by the browser and saves it
+func (app *application) passkeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.popWebAuthnSession(r, "webauthnRegistration")
+	if !ok {
+		app.apiError(w, r, http.StatusBadRequest, "no passkey registration in progress")
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		app.apiError(w, r, http.StatusUnprocessableEntity, "the name cannot be more than 100 characters")
+		return
+	}
+
+	u, err := app.loadWebAuthnUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	credential, err := app.webAuthn.FinishRegistration(u, session, r)
+	if err != nil {
+		app.apiError(w, r, http.StatusBadRequest, "the passkey could not be verified")
+		return
+	}
+
+	js, err := json.Marshal(credential)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.passkeys.Insert(u.user.ID, name, credential.ID, js, credential.Authenticator.SignCount)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, u.user.ID, models.AuditPasskeyAdd, userTarget(u.user.ID))
+
+	//the script follows the redirect
+	app.sessionManager.Put(r.Context(), "flash", "Your passkey has been added")
+	http.Redirect(w, r, "/user/passkeys", http.StatusSeeOther)
+}
+
+func (app *application) passkeyDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	err = app.passkeys.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.audit(r, userID, models.AuditPasskeyRemove, userTarget(userID))
+
+	app.sessionManager.Put(r.Context(), "flash", "Your passkey has been removed")
+	http.Redirect(w, r, "/user/passkeys", http.StatusSeeOther)
+}
+
+// passkeyLoginBegin starts a login with any passkey the browser has for this site
+func (app *application) passkeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	options, session, err := app.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.putWebAuthnSession(r, "webauthnLogin", session)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, options)
+}
+
+// passkeyLoginFinish checks the browser's assertion and logs the passkey's owner in. A passkey
+// with user verification is already two factors, so there's no TOTP step.
+func (app *application) passkeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.popWebAuthnSession(r, "webauthnLogin")
+	if !ok {
+		app.apiError(w, r, http.StatusBadRequest, "no passkey login in progress")
+		return
+	}
+
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, models.ErrNoRecord
+		}
+		return app.loadWebAuthnUser(id)
+	}
+
+	user, credential, err := app.webAuthn.FinishPasskeyLogin(findUser, session, r)
+	if err != nil {
+		app.audit(r, 0, models.AuditLoginFailed, "passkey")
+		app.apiError(w, r, http.StatusUnauthorized, "that passkey isn't registered with an account")
+		return
+	}
+	u := user.(*webAuthnUser)
+
+	if credential.Authenticator.CloneWarning {
+		app.audit(r, 0, models.AuditLoginFailed, userTarget(u.user.ID))
+		app.apiError(w, r, http.StatusUnauthorized, "that passkey may have been copied, please use your password")
+		return
+	}
+
+	passkey, err := app.passkeys.GetByCredentialID(credential.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	js, err := json.Marshal(credential)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.passkeys.Use(passkey.ID, js, credential.Authenticator.SignCount)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.audit(r, 0, models.AuditLoginFailed, userTarget(u.user.ID))
+			app.apiError(w, r, http.StatusUnauthorized, "that passkey may have been copied, please use your password")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if u.user.Disabled {
+		app.apiError(w, r, http.StatusForbidden, "This account has been disabled")
+		return
+	}
+	if !app.unverifiedLogin && !u.user.EmailVerified {
+		app.apiError(w, r, http.StatusForbidden, "Please verify your email address before logging in")
+		return
+	}
+
+	//the script follows the redirect
+	app.completeLogin(w, r, u.user.ID, false)
+}
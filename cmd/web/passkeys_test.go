@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"testing"
+)
+
+// softAuthenticator is a passkey authenticator in software. It makes one discoverable
+// ES256 credential with "none" attestation, and always verifies the user.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+// authenticator data flags
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+var b64 = base64.RawURLEncoding
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+// webAuthnOptions is the part of the options for navigator.credentials.create and
+// navigator.credentials.get that the authenticator needs
+type webAuthnOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+// authData builds authenticator data for the relying party, with the attested credential if attest is true
+func (a *softAuthenticator) authData(t *testing.T, rpID string, attest bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+
+	flags := byte(flagUserPresent | flagUserVerified)
+	if attest {
+		flags |= flagAttestedCredData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attest {
+		publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+			PublicKeyData: webauthncose.PublicKeyData{
+				KeyType:   int64(webauthncose.EllipticKey),
+				Algorithm: int64(webauthncose.AlgES256),
+			},
+			Curve:  int64(webauthncose.P256),
+			XCoord: a.key.X.FillBytes(make([]byte, 32)),
+			YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//an all zero AAGUID, then the credential id with its length
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, publicKey...)
+	}
+	return data
+}
+
+func clientDataJSON(t *testing.T, ceremony, challenge, origin string) []byte {
+	js, err := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+// create answers registration options like navigator.credentials.create
+func (a *softAuthenticator) create(t *testing.T, options webAuthnOptions, origin string) map[string]any {
+	a.userHandle, _ = b64.DecodeString(options.PublicKey.User.ID)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, options.PublicKey.RP.ID, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64.EncodeToString(clientDataJSON(t, "webauthn.create", options.PublicKey.Challenge, origin)),
+			"attestationObject": b64.EncodeToString(attestationObject),
+		},
+	}
+}
+
+// get answers login options like navigator.credentials.get, counting the signature
+func (a *softAuthenticator) get(t *testing.T, options webAuthnOptions, origin string) map[string]any {
+	a.signCount++
+
+	authData := a.authData(t, options.PublicKey.RPID, false)
+	clientData := clientDataJSON(t, "webauthn.get", options.PublicKey.Challenge, origin)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	}
+}
+
+func decodeOptions(t *testing.T, body string) webAuthnOptions {
+	var options webAuthnOptions
+	if err := json.Unmarshal([]byte(body), &options); err != nil {
+		t.Fatalf("decoding options %q: %s", body, err)
+	}
+	return options
+}
+
+// registerPasskey adds a passkey for the logged in user, confirmed with their password
+func registerPasskey(t *testing.T, ts *testServer, a *softAuthenticator, password string) {
+	_, _, page := ts.get(t, "/user/passkeys")
+	csrfToken := extractCSRFToken(t, page)
+
+	status, _, body := ts.postJSON(t, "/user/passkeys/register/begin", csrfToken, map[string]string{"password": password})
+	if status != http.StatusOK {
+		t.Fatalf("register begin: got status %d: %s", status, body)
+	}
+
+	credential := a.create(t, decodeOptions(t, body), ts.URL)
+	status, header, body := ts.postJSON(t, "/user/passkeys/register/finish?name=Laptop", csrfToken, credential)
+	if status != http.StatusSeeOther || header.Get("Location") != "/user/passkeys" {
+		t.Fatalf("register finish: got status %d to %q: %s", status, header.Get("Location"), body)
+	}
+}
+
+// loginWithPasskey logs in with whichever passkey a has, returning the status and redirect of the finishing request
+func loginWithPasskey(t *testing.T, ts *testServer, a *softAuthenticator) (int, string) {
+	_, _, page := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, page)
+
+	status, _, body := ts.postJSON(t, "/user/login/passkey/begin", csrfToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("login begin: got status %d: %s", status, body)
+	}
+
+	assertion := a.get(t, decodeOptions(t, body), ts.URL)
+	status, header, _ := ts.postJSON(t, "/user/login/passkey/finish", csrfToken, assertion)
+	return status, header.Get("Location")
+}
+
+func TestPasskeyRegisterRequiresPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	newTestUser(t, app, "Alice", "alice@example.com", "correct horse battery")
+	ts.login(t, "alice@example.com", "correct horse battery")
+
+	_, _, page := ts.get(t, "/user/passkeys")
+	csrfToken := extractCSRFToken(t, page)
+
+	tests := []struct {
+		name string
+		body any
+	}{
+		{"No body", nil},
+		{"Blank password", map[string]string{"password": ""}},
+		{"Wrong password", map[string]string{"password": "wrong horse battery"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := ts.postJSON(t, "/user/passkeys/register/begin", csrfToken, tt.body)
+			if status != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d: %s", status, http.StatusUnprocessableEntity, body)
+			}
+		})
+	}
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	id := newTestUser(t, app, "Alice", "alice@example.com", "correct horse battery")
+	ts.login(t, "alice@example.com", "correct horse battery")
+
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, ts, a, "correct horse battery")
+
+	passkeys, err := app.passkeys.ForUser(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Name != "Laptop" {
+		t.Fatalf("got passkeys %+v, want one named Laptop", passkeys)
+	}
+
+	events, err := app.auditEvents.List(models.AuditFilter{Action: models.AuditPasskeyAdd, ActorID: id}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("got %d passkey added audit events, want 1", len(events))
+	}
+
+	//a new browser, where the passkey finds the account by itself
+	ts.resetClient(t)
+	status, location := loginWithPasskey(t, ts, a)
+	if status != http.StatusSeeOther || location != "/snippet/create" {
+		t.Fatalf("login: got status %d to %q", status, location)
+	}
+
+	status, _, _ = ts.get(t, "/user/account")
+	if status != http.StatusOK {
+		t.Errorf("account page after passkey login: got status %d, want %d", status, http.StatusOK)
+	}
+
+	//an authenticator that starts counting again looks like a copy of the passkey
+	ts.resetClient(t)
+	a.signCount = 0
+	status, _ = loginWithPasskey(t, ts, a)
+	if status != http.StatusUnauthorized {
+		t.Errorf("login with a lower signature counter: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestPasskeyUseRejectsCounterRegression(t *testing.T) {
+	app := newTestApplication(t)
+	id := newTestUser(t, app, "Alice", "alice@example.com", "correct horse battery")
+
+	err := app.passkeys.Insert(id, "Laptop", []byte("credential-id"), []byte("{}"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkey, err := app.passkeys.GetByCredentialID([]byte("credential-id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		signCount uint32
+		wantErr   error
+	}{
+		{"Lower", 4, models.ErrInvalidCredentials},
+		{"Same", 5, models.ErrInvalidCredentials},
+		{"Zero", 0, models.ErrInvalidCredentials},
+		{"Higher", 6, nil},
+		{"Replayed", 6, models.ErrInvalidCredentials},
+	}
+
+	//the cases run in order, each one sees the counter the previous ones left
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := app.passkeys.Use(passkey.ID, []byte("{}"), tt.signCount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
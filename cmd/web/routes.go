@@ -4,7 +4,16 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/ratelimit"
 	"snippetbox.rakesh.net/ui"
+	"time"
+)
+
+// rate limits per route group, each applies per client IP and per logged-in user
+var (
+	authRate   = ratelimit.Rate{Requests: 10, Period: 5 * time.Minute}
+	createRate = ratelimit.Rate{Requests: 30, Period: time.Hour}
 )
 
 func (app *application) routes() http.Handler {
@@ -18,22 +27,113 @@ func (app *application) routes() http.Handler {
 	fileServer := http.FileServer(http.FS(ui.Files))
 	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
 
+	//feeds and the event stream don't use sessions or CSRF tokens, so they bypass the dynamic middleware chain
+	router.HandlerFunc(http.MethodGet, "/feed.atom", app.feedAtom)
+	router.HandlerFunc(http.MethodGet, "/feed.rss", app.feedRSS)
+	router.HandlerFunc(http.MethodGet, "/events", app.events)
+
+	//the JSON API authenticates with bearer tokens instead of sessions, so it doesn't need CSRF protection either
+	api := alice.New(app.apiAuthenticate)
+	router.Handler(http.MethodGet, "/api/snippets", api.ThenFunc(app.apiSnippetList))
+	router.Handler(http.MethodGet, "/api/snippets/:id", api.ThenFunc(app.apiSnippetView))
+
+	apiProtected := api.Append(app.requireAPIToken)
+	router.Handler(http.MethodPost, "/api/snippets", apiProtected.Append(app.rateLimit("create", createRate)).ThenFunc(app.apiSnippetCreate))
+	router.Handler(http.MethodDelete, "/api/snippets/:id", apiProtected.ThenFunc(app.apiSnippetDelete))
+
 	//unprotected using dynamic middleware chain, use the noSurf middleware on all our 'dynamic' routes and add authenticate middleware also
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodPost, "/snippet/report/:id", dynamic.ThenFunc(app.snippetReportPost))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.forgotPassword))
+	router.Handler(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(app.resetPassword))
+	router.Handler(http.MethodGet, "/user/verify", dynamic.ThenFunc(app.verifyEmail))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodGet, "/user/login/oidc", dynamic.ThenFunc(app.oidcLogin))
+	router.Handler(http.MethodGet, "/user/login/oidc/callback", dynamic.ThenFunc(app.oidcCallback))
+
+	//rate limited to slow down credential stuffing and mass signups
+	auth := dynamic.Append(app.rateLimit("auth", authRate))
+	router.Handler(http.MethodPost, "/user/signup", auth.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodPost, "/user/login", auth.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodPost, "/user/password/forgot", auth.ThenFunc(app.forgotPasswordPost))
+	router.Handler(http.MethodPost, "/user/password/reset", auth.ThenFunc(app.resetPasswordPost))
+	router.Handler(http.MethodPost, "/user/verify", auth.ThenFunc(app.verifyEmailPost))
+	router.Handler(http.MethodPost, "/user/login/2fa", auth.ThenFunc(app.userLoginTwoFactorPost))
+	router.Handler(http.MethodPost, "/user/login/passkey/begin", auth.ThenFunc(app.passkeyLoginBegin))
+	router.Handler(http.MethodPost, "/user/login/passkey/finish", auth.ThenFunc(app.passkeyLoginFinish))
 
 	//protected (authenticated only)
 	protected := dynamic.Append(app.requireAuthentication)
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodGet, "/user/export", protected.ThenFunc(app.userExport))
+	router.Handler(http.MethodGet, "/user/webhooks", protected.ThenFunc(app.webhookList))
+	router.Handler(http.MethodPost, "/user/webhooks", protected.ThenFunc(app.webhookCreatePost))
+	router.Handler(http.MethodGet, "/user/webhooks/:id", protected.ThenFunc(app.webhookView))
+	router.Handler(http.MethodPost, "/user/webhooks/:id/delete", protected.ThenFunc(app.webhookDeletePost))
+	router.Handler(http.MethodGet, "/user/tokens", protected.ThenFunc(app.apiTokenList))
+	router.Handler(http.MethodPost, "/user/tokens", protected.ThenFunc(app.apiTokenCreatePost))
+	router.Handler(http.MethodPost, "/user/tokens/:id/delete", protected.ThenFunc(app.apiTokenDeletePost))
+	router.Handler(http.MethodGet, "/user/2fa", protected.ThenFunc(app.twoFactorSettings))
+	router.Handler(http.MethodGet, "/user/2fa/qr.png", protected.ThenFunc(app.twoFactorQRCode))
+	router.Handler(http.MethodPost, "/user/2fa/setup", protected.ThenFunc(app.twoFactorSetupPost))
+	router.Handler(http.MethodPost, "/user/2fa/enable", protected.ThenFunc(app.twoFactorEnablePost))
+	router.Handler(http.MethodPost, "/user/2fa/recovery-codes", protected.ThenFunc(app.twoFactorRecoveryCodesPost))
+	router.Handler(http.MethodPost, "/user/2fa/disable", protected.ThenFunc(app.twoFactorDisablePost))
+	router.Handler(http.MethodGet, "/user/passkeys", protected.ThenFunc(app.passkeyList))
+	router.Handler(http.MethodPost, "/user/passkeys/register/finish", protected.ThenFunc(app.passkeyRegisterFinish))
+	//httprouter can't have a parameter next to the register paths, so the id goes last
+	router.Handler(http.MethodPost, "/user/passkeys/delete/:id", protected.ThenFunc(app.passkeyDeletePost))
+	router.Handler(http.MethodGet, "/user/account", protected.ThenFunc(app.account))
+	router.Handler(http.MethodPost, "/user/account/name", protected.ThenFunc(app.accountNamePost))
+	//likewise the id goes last here, next to revoke-all
+	router.Handler(http.MethodPost, "/user/sessions/revoke/:id", protected.ThenFunc(app.sessionRevokePost))
+	router.Handler(http.MethodPost, "/user/sessions/revoke-all", protected.ThenFunc(app.sessionRevokeAllPost))
+
+	//these check the current password, so they're limited like the login form
+	reauth := protected.Append(app.rateLimit("auth", authRate))
+	router.Handler(http.MethodPost, "/user/account/email", reauth.ThenFunc(app.accountEmailPost))
+	router.Handler(http.MethodPost, "/user/account/password", reauth.ThenFunc(app.accountPasswordPost))
+	router.Handler(http.MethodPost, "/user/account/delete", reauth.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodPost, "/user/passkeys/register/begin", reauth.ThenFunc(app.passkeyRegisterBegin))
+
+	//only users with a verified email address can create snippets
+	verified := protected.Append(app.requireVerifiedEmail)
+	router.Handler(http.MethodGet, "/snippet/create", verified.ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodGet, "/snippet/import", verified.ThenFunc(app.snippetImport))
+
+	//rate limited to slow down spam, shared with the API
+	create := verified.Append(app.rateLimit("create", createRate))
+	router.Handler(http.MethodPost, "/snippet/create", create.ThenFunc(app.snippetCreatePost))
+	//noSurf parses the whole multipart body looking for the CSRF token, so the size limit goes first,
+	//with some room for the multipart headers and the token
+	router.Handler(http.MethodPost, "/snippet/import", maxBytes(maxImportSize+1<<20)(create.ThenFunc(app.snippetImportPost)))
+
+	//moderators and admins
+	moderator := dynamic.Append(app.requireRole(models.RoleModerator))
+	router.Handler(http.MethodGet, "/moderation", moderator.ThenFunc(app.moderationQueue))
+	router.Handler(http.MethodPost, "/moderation/snippets/:id/hide", moderator.ThenFunc(app.moderationHidePost))
+	router.Handler(http.MethodPost, "/moderation/snippets/:id/delete", moderator.ThenFunc(app.moderationDeletePost))
+	router.Handler(http.MethodPost, "/moderation/reports/:id/dismiss", moderator.ThenFunc(app.moderationDismissPost))
+
+	//admin only, requireRole also handles anonymous users
+	admin := dynamic.Append(app.requireRole(models.RoleAdmin))
+	router.Handler(http.MethodGet, "/admin", admin.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/:id/role", admin.ThenFunc(app.adminUserRolePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/disable", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/enable", admin.ThenFunc(app.adminUserEnablePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/unlock", admin.ThenFunc(app.adminUserUnlockPost))
+	router.Handler(http.MethodGet, "/admin/snippets", admin.ThenFunc(app.adminSnippets))
+	router.Handler(http.MethodGet, "/admin/audit", admin.ThenFunc(app.adminAudit))
+	router.Handler(http.MethodGet, "/admin/audit/export", admin.ThenFunc(app.adminAuditExport))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/expire", admin.ThenFunc(app.adminSnippetExpirePost))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/delete", admin.ThenFunc(app.adminSnippetDeletePost))
 
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	standard := alice.New(app.requestID, app.recoverPanic, app.logRequest, secureHeaders)
 
 	return standard.Then(router)
 }
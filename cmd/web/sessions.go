@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"snippetbox.rakesh.net/internal/models"
+	"time"
+)
+
+// reauthTimeout is how long a login confirms changes for users who have no password to enter
+const reauthTimeout = 10 * time.Minute
+
+// startUserSession logs the user in on this session and records where from, so it
+// shows up in their list of sessions and can be revoked. With remember the session
+// lasts for rememberLifetime and its cookie outlives the browser.
+func (app *application) startUserSession(r *http.Request, userID int, remember bool) error {
+	//logging in again in the same browser replaces its old session
+	if old := app.sessionManager.GetString(r.Context(), "userSessionID"); old != "" {
+		err := app.userSessions.Delete(old, app.sessionManager.GetInt(r.Context(), "authenticatedID"))
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+	}
+
+	//changing the session id, its good practice to do when the authentication state or privilege levels changes for the user
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	if remember {
+		app.sessionManager.SetDeadline(r.Context(), time.Now().Add(app.rememberLifetime).UTC())
+	}
+	app.sessionManager.RememberMe(r.Context(), remember)
+	app.sessionManager.Put(r.Context(), "remembered", remember)
+
+	ttl := time.Until(app.sessionManager.Deadline(r.Context()))
+	id, err := app.userSessions.Insert(userID, clientIP(r), r.UserAgent(), ttl)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedID", userID)
+	app.sessionManager.Put(r.Context(), "userSessionID", id)
+	app.sessionManager.Put(r.Context(), "authenticatedAt", time.Now().Unix())
+	return nil
+}
+
+// recentlyAuthenticated reports whether the user logged in on this session within reauthTimeout
+func (app *application) recentlyAuthenticated(r *http.Request) bool {
+	authenticatedAt := time.Unix(app.sessionManager.GetInt64(r.Context(), "authenticatedAt"), 0)
+	return time.Since(authenticatedAt) < reauthTimeout
+}
+
+// endUserSession logs the user out of this session only
+func (app *application) endUserSession(r *http.Request) error {
+	id := app.sessionManager.GetString(r.Context(), "userSessionID")
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedID")
+
+	//ErrNoRecord means the session was already revoked, e.g. along with all the others
+	err := app.userSessions.Delete(id, userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), "authenticatedID")
+	app.sessionManager.Remove(r.Context(), "userSessionID")
+	app.sessionManager.Remove(r.Context(), "remembered")
+	app.sessionManager.Remove(r.Context(), "authenticatedAt")
+	app.sessionManager.RememberMe(r.Context(), false)
+	return nil
+}
+
+// sessionRevokePost logs out one of the user's other sessions, it stops working on its next request
+func (app *application) sessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+
+	//the current session is ended with the logout button, which also clears this browser's state
+	if id == app.sessionManager.GetString(r.Context(), "userSessionID") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := app.userSessions.Delete(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.authenticatedUserID(r), models.AuditSessionRevoke, userTarget(app.authenticatedUserID(r)))
+	app.sessionManager.Put(r.Context(), "flash", "The session has been logged out")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// sessionRevokeAllPost logs the user out everywhere, including this browser
+func (app *application) sessionRevokeAllPost(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+	err := app.destroyUserSessions(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, id, models.AuditSessionRevoke, userTarget(id))
+
+	err = app.endUserSession(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out everywhere")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
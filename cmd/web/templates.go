@@ -4,27 +4,64 @@ import (
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"slices"
 	"snippetbox.rakesh.net/internal/models"
 	"snippetbox.rakesh.net/ui"
 	"time"
 )
 
 type templateData struct {
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string
+	CurrentYear       int
+	Snippet           *models.Snippet
+	Snippets          []*models.Snippet
+	Form              any
+	Flash             string
+	IsAuthenticated   bool
+	IsVerified        bool
+	LocalSignup       bool
+	SSOName           string
+	IsModerator       bool
+	IsAdmin           bool
+	CSRFToken         string
+	ImportResults     []importResult
+	Webhook           *models.Webhook
+	Webhooks          []*models.Webhook
+	WebhookDeliveries []*models.WebhookDelivery
+	WebhookEvents     []string
+	APITokens         []*models.APIToken
+	NewAPIToken       string
+	Users             []*models.User
+	Roles             []string
+	Query             string
+	AdminStats        adminStats
+	Reports           []*models.Report
+	ReportReasons     []string
+	TwoFactorEnabled  bool
+	TwoFactorSecret   string
+	RecoveryCodes     []string
+	Passkeys          []*models.Passkey
+	User              *models.User
+	HasPassword       bool
+	UserSessions      []*models.UserSession
+	CurrentSessionID  string
+	AuditEvents       []*models.AuditEvent
+	AuditActions      []string
+	AuditNextURL      string
+	AuditExportURL    string
 }
 
 func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
+// contains reports whether the slice holds the value, e.g. to keep checkboxes ticked
+func contains(values []string, value string) bool {
+	return slices.Contains(values, value)
+}
+
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"contains":  contains,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-playground/form/v4"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"snippetbox.rakesh.net/internal/mailer"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/ratelimit"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB connects to the MySQL database named by SNIPPETBOX_TEST_DSN, e.g.
+// "test_web:pass@/test_snippetbox?parseTime=true&multiStatements=true", and applies
+// the migrations to it. Every table is dropped again when the test finishes, so the
+// database must be one that's only used for testing. Tests are skipped without it.
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN isn't set")
+	}
+
+	db, err := openDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a previous run that was killed may have left its tables behind
+	dropTestTables(t, db)
+
+	migrations, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		script, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(script)); err != nil {
+			t.Fatalf("%s: %s", filepath.Base(migration), err)
+		}
+	}
+
+	t.Cleanup(func() {
+		dropTestTables(t, db)
+		db.Close()
+	})
+	return db
+}
+
+// dropTestTables drops every table in the test database
+func dropTestTables(t *testing.T, db *sql.DB) {
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, "`"+table+"`")
+	}
+	rows.Close()
+	if len(tables) == 0 {
+		return
+	}
+
+	//the foreign key checks are per connection, so the drop has to run on the same one
+	_, err = db.Exec(`SET FOREIGN_KEY_CHECKS = 0; DROP TABLE ` + strings.Join(tables, ", ") + `; SET FOREIGN_KEY_CHECKS = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestApplication returns an application backed by the test database. Sessions are
+// kept in memory, and email is logged to nowhere.
+func newTestApplication(t *testing.T) *application {
+	db := newTestDB(t)
+
+	templateCache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sessionManager := scs.New()
+	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Cookie.Secure = true
+
+	return &application{
+		logger:           logger,
+		snippets:         &models.SnippetModel{DB: db},
+		users:            &models.UserModel{DB: db},
+		webhooks:         &models.WebhookModel{DB: db},
+		apiTokens:        &models.APITokenModel{DB: db},
+		reports:          &models.ReportModel{DB: db},
+		loginThrottle:    &models.LoginThrottleModel{DB: db},
+		templateCache:    templateCache,
+		formDecoder:      form.NewDecoder(),
+		sessionManager:   sessionManager,
+		rememberLifetime: 30 * 24 * time.Hour,
+		broadcaster:      newSnippetBroadcaster(),
+		limiter:          ratelimit.NewMemoryLimiter(),
+		passwordResets:   &models.PasswordResetModel{DB: db},
+		twoFactor:        &models.TwoFactorModel{DB: db},
+		passkeys:         &models.PasskeyModel{DB: db},
+		identities:       &models.IdentityModel{DB: db},
+		userSessions:     &models.UserSessionModel{DB: db},
+		auditEvents:      &models.AuditModel{DB: db},
+		localSignup:      true,
+		mailer:           &mailer.LogMailer{Log: logger, From: "no-reply@snippetbox.local"},
+		signingKey:       bytes.Repeat([]byte("k"), 32),
+	}
+}
+
+// testServer is an HTTPS server for the application, with a client that keeps cookies
+// and doesn't follow redirects
+type testServer struct {
+	*httptest.Server
+	client *http.Client
+}
+
+// newTestServer starts serving app, whose public URL and passkey origin become the server's
+func newTestServer(t *testing.T, app *application) *testServer {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	app.baseURL = ts.URL
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          "127.0.0.1",
+		RPDisplayName: "Snippetbox",
+		RPOrigins:     []string{ts.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.webAuthn = webAuthn
+	ts.Config.Handler = app.routes()
+
+	s := &testServer{Server: ts}
+	s.resetClient(t)
+	return s
+}
+
+// resetClient forgets the client's cookies, like a new browser
+func (ts *testServer) resetClient(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.client = ts.Client()
+	ts.client.Jar = jar
+	ts.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+}
+
+// do sends req and returns the status, headers and body of the response
+func (ts *testServer) do(t *testing.T, req *http.Request) (int, http.Header, string) {
+	rs, err := ts.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.do(t, req)
+}
+
+func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return ts.do(t, req)
+}
+
+// postJSON posts body as JSON with the CSRF token in a header, like passkeys.js
+func (ts *testServer) postJSON(t *testing.T, urlPath, csrfToken string, body any) (int, http.Header, string) {
+	js, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, bytes.NewReader(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", csrfToken)
+	return ts.do(t, req)
+}
+
+// login logs in with a password through the login form
+func (ts *testServer) login(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+
+	status, header, _ := ts.postForm(t, "/user/login", url.Values{
+		"email":      {email},
+		"password":   {password},
+		"csrf_token": {extractCSRFToken(t, body)},
+	})
+	if status != http.StatusSeeOther || header.Get("Location") != "/snippet/create" {
+		t.Fatalf("logging in as %s: got status %d to %q", email, status, header.Get("Location"))
+	}
+}
+
+var csrfTokenRX = regexp.MustCompile(`(?:name="csrf_token" value="|data-csrf-token=')([^"']+)`)
+
+// extractCSRFToken returns the first CSRF token in a page, from a form or a script's data attribute
+func extractCSRFToken(t *testing.T, body string) string {
+	matches := csrfTokenRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no csrf token found in body")
+	}
+	return html.UnescapeString(matches[1])
+}
+
+// newTestUser creates a user with a verified email address and returns their id
+func newTestUser(t *testing.T, app *application, name, email, password string) int {
+	id, err := app.users.Insert(name, email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.users.VerifyEmail(id, email); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"rsc.io/qr"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/totp"
+	"snippetbox.rakesh.net/internal/validator"
+	"time"
+)
+
+const (
+	// how long the user has to enter their code after their password
+	twoFactorLoginTimeout = 5 * time.Minute
+	// wrong codes allowed before the user has to enter their password again
+	maxTwoFactorAttempts = 5
+	// issuer shown by authenticator apps
+	totpIssuer = "Snippetbox"
+)
+
+type twoFactorCodeForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// checkTwoFactorCode reports whether code is either the user's current TOTP code or one of their recovery codes,
+// using it up either way
+func (app *application) checkTwoFactorCode(userID int, code string) (bool, error) {
+	secret, err := app.twoFactor.Secret(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		err = app.twoFactor.UseStep(userID, step)
+	} else {
+		_, err = app.twoFactor.UseRecoveryCode(userID, code)
+	}
+
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// pendingTwoFactorUser returns the id of the user who has entered their password but not yet their code, or 0
+func (app *application) pendingTwoFactorUser(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+	started := time.Unix(app.sessionManager.GetInt64(r.Context(), "twoFactorStarted"), 0)
+
+	if id == 0 || time.Since(started) > twoFactorLoginTimeout {
+		app.clearPendingTwoFactor(r)
+		return 0
+	}
+	return id
+}
+
+func (app *application) clearPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+	app.sessionManager.Remove(r.Context(), "twoFactorRemember")
+}
+
+// userLoginTwoFactor is the second step of logging in, after the password
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUser(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorCodeForm{}
+	app.render(w, r, http.StatusOK, "login_2fa.tmpl", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUser(r)
+	if id == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "Your login timed out, Please Login again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorCodeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if form.Valid() {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		//codes are refused during a lockout just like passwords, which count towards it too
+		if user.Locked() {
+			app.clearPendingTwoFactor(r)
+			app.sessionManager.Put(r.Context(), "flash", "This account is temporarily locked after too many failed login attempts. Please try again later")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		ok, err := app.checkTwoFactorCode(id, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if ok {
+			err = app.users.Unlock(id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			remember := app.sessionManager.GetBool(r.Context(), "twoFactorRemember")
+			app.clearPendingTwoFactor(r)
+			app.completeLogin(w, r, id, remember)
+			return
+		}
+
+		app.audit(r, 0, models.AuditLoginFailed, userTarget(id))
+		err = app.users.RecordFailedLogin(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		//the password has been checked already, but the code can't be guessed indefinitely
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= maxTwoFactorAttempts {
+			app.clearPendingTwoFactor(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes, Please Login again")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+		form.AddNonFieldError("That code is incorrect")
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, http.StatusUnprocessableEntity, "login_2fa.tmpl", data)
+}
+
+// twoFactorSettings shows whether two-factor authentication is on, and the QR code while it's being set up
+func (app *application) twoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, http.StatusOK, twoFactorCodeForm{}, nil)
+}
+
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, form twoFactorCodeForm, recoveryCodes []string) {
+	enabled, err := app.twoFactor.Enabled(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.TwoFactorEnabled = enabled
+	data.TwoFactorSecret = app.sessionManager.GetString(r.Context(), "totpPendingSecret")
+	data.RecoveryCodes = recoveryCodes
+	app.render(w, r, status, "two_factor.tmpl", data)
+}
+
+// refuseIfTwoFactorEnabled redirects back to the settings page and returns true if the user already has
+// two-factor authentication on, replacing the secret would otherwise skip asking for a current code
+func (app *application) refuseIfTwoFactorEnabled(w http.ResponseWriter, r *http.Request) bool {
+	enabled, err := app.twoFactor.Enabled(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return true
+	}
+	if enabled {
+		app.sessionManager.Remove(r.Context(), "totpPendingSecret")
+		app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is already on, turn it off first to use a new device")
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return true
+	}
+	return false
+}
+
+// twoFactorSetupPost generates a new secret, which isn't used until the user confirms it with a code
+func (app *application) twoFactorSetupPost(w http.ResponseWriter, r *http.Request) {
+	if app.refuseIfTwoFactorEnabled(w, r) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "totpPendingSecret", secret)
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// twoFactorQRCode renders the secret being set up as a QR code for authenticator apps to scan
+func (app *application) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "totpPendingSecret")
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	code, err := qr.Encode(totp.URL(totpIssuer, user.Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	//large enough to scan from a screen
+	code.Scale = 6
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+// twoFactorEnablePost turns on two-factor authentication once the user proves their app has the secret
+func (app *application) twoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "totpPendingSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+	if app.refuseIfTwoFactorEnabled(w, r) {
+		return
+	}
+
+	var form twoFactorCodeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(secret, form.Code, time.Now())
+	form.CheckField(ok, "code", "That code is incorrect, check the time on your device is correct")
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(app.authenticatedUserID(r), secret, step)
+	if err != nil {
+		//most likely turned on from another tab in the meantime
+		if !errors.Is(err, models.ErrNoRecord) || !app.refuseIfTwoFactorEnabled(w, r) {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "totpPendingSecret")
+	app.audit(r, app.authenticatedUserID(r), models.AuditTwoFactorEnable, userTarget(app.authenticatedUserID(r)))
+
+	//like new API tokens, recovery codes are shown once on this response and never stored in the session
+	app.renderTwoFactor(w, r, http.StatusOK, twoFactorCodeForm{}, codes)
+}
+
+// twoFactorRecoveryCodesPost replaces the user's recovery codes, which takes a current code
+func (app *application) twoFactorRecoveryCodesPost(w http.ResponseWriter, r *http.Request) {
+	form, ok := app.confirmTwoFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.twoFactor.RegenerateRecoveryCodes(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderTwoFactor(w, r, http.StatusOK, form, codes)
+}
+
+// twoFactorDisablePost turns off two-factor authentication, which takes a current code
+func (app *application) twoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	_, ok := app.confirmTwoFactor(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.twoFactor.Disable(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, app.authenticatedUserID(r), models.AuditTwoFactorDisable, userTarget(app.authenticatedUserID(r)))
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been turned off")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// confirmTwoFactor checks the code posted with a change to two-factor settings. If it's
+// wrong, the settings page is rendered again and ok is false.
+func (app *application) confirmTwoFactor(w http.ResponseWriter, r *http.Request) (form twoFactorCodeForm, ok bool) {
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return form, false
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if form.Valid() {
+		valid, err := app.checkTwoFactorCode(app.authenticatedUserID(r), form.Code)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return form, false
+		}
+		form.CheckField(valid, "code", "That code is incorrect")
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form, nil)
+		return form, false
+	}
+	return twoFactorCodeForm{}, true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"snippetbox.rakesh.net/internal/totp"
+	"testing"
+	"time"
+)
+
+var pendingSecretRX = regexp.MustCompile(`enter the key <code>([A-Z2-7=]+)</code>`)
+
+func TestTwoFactorSetupRefusedWhenEnabled(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	id := newTestUser(t, app, "Alice", "alice@example.com", "correct horse battery")
+	ts.login(t, "alice@example.com", "correct horse battery")
+
+	_, _, page := ts.get(t, "/user/2fa")
+	ts.postForm(t, "/user/2fa/setup", url.Values{"csrf_token": {extractCSRFToken(t, page)}})
+	_, _, page = ts.get(t, "/user/2fa")
+	matches := pendingSecretRX.FindStringSubmatch(page)
+	if len(matches) < 2 {
+		t.Fatal("no secret on the settings page after setup")
+	}
+	pending := matches[1]
+
+	//two-factor authentication gets turned on from another tab in the meantime
+	enabled, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.twoFactor.Enable(id, enabled, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.Code(pending, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, header, _ := ts.postForm(t, "/user/2fa/enable", url.Values{"code": {code}, "csrf_token": {extractCSRFToken(t, page)}})
+	if status != http.StatusSeeOther || header.Get("Location") != "/user/2fa" {
+		t.Errorf("enable: got status %d to %q, want a redirect to /user/2fa", status, header.Get("Location"))
+	}
+
+	status, header, _ = ts.postForm(t, "/user/2fa/setup", url.Values{"csrf_token": {extractCSRFToken(t, page)}})
+	if status != http.StatusSeeOther || header.Get("Location") != "/user/2fa" {
+		t.Errorf("setup: got status %d to %q, want a redirect to /user/2fa", status, header.Get("Location"))
+	}
+
+	_, _, page = ts.get(t, "/user/2fa")
+	if pendingSecretRX.MatchString(page) {
+		t.Error("settings page offers a new secret while two-factor authentication is on")
+	}
+
+	secret, err := app.twoFactor.Secret(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != enabled {
+		t.Error("the secret was replaced without a current code")
+	}
+}
+
+func TestTwoFactorWrongCodesLockAccount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	id := newTestUser(t, app, "Alice", "alice@example.com", "correct horse battery")
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.twoFactor.Enable(id, secret, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	//a code from well outside the window that's accepted
+	step := totp.Step(time.Now())
+	wrongCode, err := totp.Code(secret, step+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//logs in with the right password, then posts codes, returning the status and redirect of the last one
+	login := func(codes ...string) (int, string) {
+		_, _, page := ts.get(t, "/user/login")
+		status, header, _ := ts.postForm(t, "/user/login", url.Values{
+			"email":      {"alice@example.com"},
+			"password":   {"correct horse battery"},
+			"csrf_token": {extractCSRFToken(t, page)},
+		})
+		if status != http.StatusSeeOther || header.Get("Location") != "/user/login/2fa" {
+			t.Fatalf("password: got status %d to %q", status, header.Get("Location"))
+		}
+
+		_, _, page = ts.get(t, "/user/login/2fa")
+		csrfToken := extractCSRFToken(t, page)
+		for _, code := range codes {
+			status, header, _ = ts.postForm(t, "/user/login/2fa", url.Values{"code": {code}, "csrf_token": {csrfToken}})
+		}
+		return status, header.Get("Location")
+	}
+
+	//failures up to the point where the lockout starts to delay logins
+	login(wrongCode, wrongCode, wrongCode)
+
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the right password doesn't start the count afresh, so one more wrong code locks the
+	//account briefly, and even the right code is refused
+	status, location := login(wrongCode, code)
+	if status != http.StatusSeeOther || location != "/user/login" {
+		t.Errorf("right code while locked: got status %d to %q, want a redirect to /user/login", status, location)
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Locked() {
+		t.Error("account isn't locked after four wrong codes")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"snippetbox.rakesh.net/internal/mailer"
+	"snippetbox.rakesh.net/internal/models"
+	"snippetbox.rakesh.net/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long an emailed verification link works for
+const emailVerificationTTL = 7 * 24 * time.Hour
+
+var errInvalidSignature = errors.New("invalid or expired signature")
+
+type verifyEmailForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// signVerification returns a token proving that the link was sent to email for the user,
+// which stays valid until expires. Changing the address invalidates it.
+func (app *application) signVerification(userID int, email string, expires time.Time) string {
+	payload := fmt.Sprintf("%d:%d:%s", userID, expires.Unix(), email)
+
+	mac := hmac.New(sha256.New, app.signingKey)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkVerification returns the user id and email address of a token made by signVerification
+func (app *application) checkVerification(token string) (int, string, error) {
+	encodedPayload, encodedSum, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", errInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, "", errInvalidSignature
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(encodedSum)
+	if err != nil {
+		return 0, "", errInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, app.signingKey)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return 0, "", errInvalidSignature
+	}
+
+	//the email address goes last since it's the only part that can contain a colon
+	parts := strings.SplitN(string(payload), ":", 3)
+	if len(parts) != 3 {
+		return 0, "", errInvalidSignature
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", errInvalidSignature
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, "", errInvalidSignature
+	}
+
+	return userID, parts[2], nil
+}
+
+// sendVerificationEmail emails the user a link that verifies their email address
+func (app *application) sendVerificationEmail(user *models.User) {
+	token := app.signVerification(user.ID, user.Email, time.Now().Add(emailVerificationTTL))
+	link := app.absoluteURL("/user/verify?token=" + url.QueryEscape(token))
+
+	app.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease follow this link within the next week to verify your email address:\n\n%s\n\n"+
+			"If you didn't sign up for Snippetbox, you can ignore this email.\n", user.Name, link),
+	})
+}
+
+// verifyEmail verifies the address in the token of an emailed link, or without a token shows the form to send another link
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		form := verifyEmailForm{}
+		if app.isAuthenticated(r) {
+			user, err := app.users.Get(app.authenticatedUserID(r))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.Email = user.Email
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusOK, "verify_email.tmpl", data)
+		return
+	}
+
+	id, email, err := app.checkVerification(token)
+	if err == nil {
+		err = app.users.VerifyEmail(id, email)
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidSignature) || errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That verification link is invalid or has expired, please ask for a new one")
+			http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified")
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+}
+
+// verifyEmailPost sends another verification link
+func (app *application) verifyEmailPost(w http.ResponseWriter, r *http.Request) {
+	var form verifyEmailForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must contain valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "verify_email.tmpl", data)
+		return
+	}
+
+	//like the forgotten password form, the response doesn't say whether the account exists
+	id, err := app.users.IDByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if id != 0 {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !user.Disabled && !user.EmailVerified {
+			app.sendVerificationEmail(user)
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If that email address still needs verifying, we've sent it a new link")
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
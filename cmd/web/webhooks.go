@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"snippetbox.rakesh.net/internal/models"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// events that webhooks can subscribe to
+const (
+	eventSnippetCreated = "snippet.created"
+)
+
+var webhookEvents = []string{eventSnippetCreated}
+
+const (
+	// how often the dispatcher polls the queue, and how many deliveries it sends per poll
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 20
+
+	// how long a single delivery can take
+	webhookTimeout = 10 * time.Second
+
+	// claimed deliveries are hidden from other instances for this long, deliveries are sent one
+	// at a time so the lease has to outlast a whole batch of them timing out
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+
+	// retries back off exponentially from webhookBaseDelay, up to webhookMaxDelay
+	webhookBaseDelay   = 30 * time.Second
+	webhookMaxDelay    = 6 * time.Hour
+	webhookMaxAttempts = 8
+)
+
+// webhookPayload is the JSON body posted to webhooks
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+type webhookSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// enqueueWebhookEvent stores the event in the delivery queue of the user's webhooks, it is sent later
+// by dispatchWebhooks so a slow or broken webhook never holds up the request that triggered it
+func (app *application) enqueueWebhookEvent(userID int, event string, data any) {
+	payload, err := json.Marshal(webhookPayload{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	})
+	if err == nil {
+		err = app.webhooks.Enqueue(userID, event, payload)
+	}
+	if err != nil {
+		app.logger.Error("enqueueing webhook failed", "event", event, "error", err)
+	}
+}
+
+// dispatchWebhooks polls the delivery queue forever, it is started in its own goroutine from main
+func (app *application) dispatchWebhooks() {
+	client := newWebhookClient()
+
+	for range time.Tick(webhookPollInterval) {
+		deliveries, err := app.webhooks.Claim(webhookBatchSize, webhookLease)
+		if err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+
+		for _, d := range deliveries {
+			app.deliverWebhook(client, d)
+		}
+	}
+}
+
+func (app *application) deliverWebhook(client *http.Client, d *models.WebhookDelivery) {
+	code, err := sendWebhook(client, d)
+
+	status := models.DeliverySucceeded
+	var retryIn time.Duration
+	var lastError string
+
+	if err != nil {
+		lastError = err.Error()
+		status = models.DeliveryFailed
+		if d.Attempts+1 < webhookMaxAttempts {
+			status = models.DeliveryPending
+			retryIn = webhookBackoff(d.Attempts + 1)
+		}
+	}
+
+	if err := app.webhooks.RecordAttempt(d.ID, status, code, lastError, retryIn); err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// newWebhookClient returns the client deliveries are sent with. Webhook URLs are chosen by
+// users, so it refuses to connect to the server's own network, see webhookDialControl.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: webhookDialControl,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	//a proxy would make the connection on our behalf, past the check
+	transport.Proxy = nil
+
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+var errWebhookAddress = errors.New("webhooks can't be sent to loopback, private or link-local addresses")
+
+// webhookDialControl runs just before each connection is made, after the host name has been
+// resolved, so a name can't pass a check and then be pointed somewhere else. This covers
+// redirects too.
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+// carrier-grade NAT addresses, which are as internal as the private ranges
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether ip is an address on the internet rather than on this
+// machine or its network, such as the cloud metadata service at 169.254.169.254
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsUnspecified() && !ip.IsMulticast() && !sharedAddressSpace.Contains(ip)
+}
+
+// isInternalWebhookURL catches URLs that obviously point inside the network when the webhook
+// is created, host names are only resolved, and checked, when deliveries are sent
+func isInternalWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return !isPublicAddr(ip)
+	}
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// sendWebhook posts a delivery, any response outside the 2xx range is an error
+func sendWebhook(client *http.Client, d *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Snippetbox-Webhook/1.0")
+	req.Header.Set("X-Snippetbox-Event", d.Event)
+	req.Header.Set("X-Snippetbox-Delivery", strconv.Itoa(d.ID))
+	req.Header.Set("X-Snippetbox-Timestamp", timestamp)
+	req.Header.Set("X-Snippetbox-Signature", "sha256="+signWebhook(d.Secret, timestamp, []byte(d.Payload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "timestamp.body", receivers recompute it
+// with their copy of the secret and should reject old timestamps to prevent replays
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before the next attempt, doubling after every failure
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseDelay
+	for i := 1; i < attempts && delay < webhookMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxDelay)
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
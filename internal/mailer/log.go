@@ -0,0 +1,15 @@
+package mailer
+
+import "log/slog"
+
+// LogMailer writes messages to a log instead of sending them, so links in
+// them can be followed during local development.
+type LogMailer struct {
+	Log  *slog.Logger
+	From string
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.Log.Info("email not sent, no SMTP server configured", "to", msg.To, "message", string(format(m.From, msg)))
+	return nil
+}
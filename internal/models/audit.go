@@ -0,0 +1,190 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Audit actions, named object.verb
+const (
+	AuditSignup           = "user.signup"
+	AuditLogin            = "user.login"
+	AuditLoginFailed      = "user.login_failed"
+	AuditLogout           = "user.logout"
+	AuditPasswordReset    = "user.password_reset"
+	AuditPasswordChange   = "user.password_change"
+	AuditEmailChange      = "user.email_change"
+	AuditAccountDelete    = "user.delete"
+	AuditSessionRevoke    = "user.session_revoke"
+	AuditTwoFactorEnable  = "user.2fa_enable"
+	AuditTwoFactorDisable = "user.2fa_disable"
+	AuditPasskeyAdd       = "user.passkey_add"
+	AuditPasskeyRemove    = "user.passkey_remove"
+	AuditAPITokenCreate   = "user.api_token_create"
+	AuditAPITokenRevoke   = "user.api_token_revoke"
+	AuditWebhookCreate    = "user.webhook_create"
+	AuditRoleChange       = "admin.role_change"
+	AuditUserDisable      = "admin.user_disable"
+	AuditUserEnable       = "admin.user_enable"
+	AuditUserUnlock       = "admin.user_unlock"
+	AuditSnippetCreate    = "snippet.create"
+	AuditSnippetExpire    = "snippet.expire"
+	AuditSnippetHide      = "snippet.hide"
+	AuditSnippetDelete    = "snippet.delete"
+)
+
+// AuditActions lists the actions in the order they're offered as filters
+var AuditActions = []string{
+	AuditSignup, AuditLogin, AuditLoginFailed, AuditLogout, AuditPasswordReset, AuditPasswordChange,
+	AuditEmailChange, AuditAccountDelete, AuditSessionRevoke, AuditTwoFactorEnable, AuditTwoFactorDisable,
+	AuditPasskeyAdd, AuditPasskeyRemove, AuditAPITokenCreate, AuditAPITokenRevoke, AuditWebhookCreate,
+	AuditRoleChange, AuditUserDisable, AuditUserEnable, AuditUserUnlock,
+	AuditSnippetCreate, AuditSnippetExpire, AuditSnippetHide, AuditSnippetDelete,
+}
+
+// AuditCLI is recorded in place of the IP address for events done with the "web admin" command
+const AuditCLI = "cli"
+
+// AuditEvent records who did what to what, and from where. ActorID is 0 when
+// nobody was logged in, e.g. for failed logins, and for the "web admin" command.
+type AuditEvent struct {
+	ID        int64
+	Created   time.Time
+	ActorID   int
+	Action    string
+	Target    string
+	IP        string
+	UserAgent string
+}
+
+// AuditFilter selects audit events, zero fields match everything. Since and
+// Until are inclusive and exclusive.
+type AuditFilter struct {
+	Action  string
+	ActorID int
+	Target  string
+	Since   time.Time
+	Until   time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert This will append an event to the audit log
+func (m *AuditModel) Insert(e *AuditEvent) error {
+	stmt := `INSERT INTO audit_events (created, actor_id, action, target, ip, user_agent)
+			 VALUES (UTC_TIMESTAMP(), ?, ?, ?, ?, ?)`
+
+	var actorID sql.NullInt64
+	if e.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: int64(e.ActorID), Valid: true}
+	}
+
+	_, err := m.DB.Exec(stmt, actorID, e.Action, truncate(e.Target, 255), e.IP, truncate(e.UserAgent, 255))
+	return err
+}
+
+// InsertCLI This will append an event done with the "web admin" command, which has no
+// user or request to record. The command is kept as the user agent.
+func (m *AuditModel) InsertCLI(action, target, command string) error {
+	return m.Insert(&AuditEvent{
+		Action:    action,
+		Target:    target,
+		IP:        AuditCLI,
+		UserAgent: "web admin " + command,
+	})
+}
+
+// List returns up to limit events matching f, newest first. Only events older
+// than before are returned unless it's 0, for paging.
+func (m *AuditModel) List(f AuditFilter, before int64, limit int) ([]*AuditEvent, error) {
+	where, args := f.where()
+	if before > 0 {
+		where += " AND id < ?"
+		args = append(args, before)
+	}
+
+	stmt := `SELECT id, created, actor_id, action, target, ip, user_agent
+			 FROM audit_events WHERE ` + where + ` ORDER BY id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Each calls fn for every event matching f, oldest first, without loading them all at once
+func (m *AuditModel) Each(f AuditFilter, fn func(*AuditEvent) error) error {
+	where, args := f.where()
+	stmt := `SELECT id, created, actor_id, action, target, ip, user_agent
+			 FROM audit_events WHERE ` + where + ` ORDER BY id ASC`
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// where builds the WHERE clause of f, the values are always passed as arguments
+func (f AuditFilter) where() (string, []any) {
+	conds := []string{"TRUE"}
+	args := []any{}
+
+	if f.Action != "" {
+		conds = append(conds, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.ActorID != 0 {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.Target != "" {
+		conds = append(conds, "target = ?")
+		args = append(args, f.Target)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "created < ?")
+		args = append(args, f.Until.UTC())
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func scanAuditEvent(row rowScanner) (*AuditEvent, error) {
+	e := &AuditEvent{}
+	var actorID sql.NullInt64
+	err := row.Scan(&e.ID, &e.Created, &actorID, &e.Action, &e.Target, &e.IP, &e.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	e.ActorID = int(actorID.Int64)
+	return e, nil
+}
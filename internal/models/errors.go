@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"errors"
 )
 
@@ -8,4 +9,20 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrAccountDisabled    = errors.New("models: account disabled")
+	ErrAccountLocked      = errors.New("models: account temporarily locked")
+	ErrLoginThrottled     = errors.New("models: too many failed logins from this address")
+	ErrInvalidToken       = errors.New("models: invalid or expired token")
 )
+
+// checkRowsAffected returns ErrNoRecord if an UPDATE or DELETE didn't match any rows
+func checkRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+)
+
+// IdentityModel links accounts at OpenID Connect providers to users
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// UserID returns the id of the user linked to the subject at issuer
+func (m *IdentityModel) UserID(issuer, subject string) (int, error) {
+	var userID int
+	stmt := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Link This will link the subject at issuer to an existing user
+func (m *IdentityModel) Link(issuer, subject string, userID int) error {
+	stmt := `INSERT INTO user_identities (issuer, subject, user_id, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, issuer, subject, userID)
+	return err
+}
+
+// Provision This will create a user without a password for the subject at issuer and return its id. It
+// returns ErrDuplicateEmail if another user already has the email address.
+func (m *IdentityModel) Provision(issuer, subject, name, email string, emailVerified bool) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO users (name, email, hashed_password, created, email_verified) VALUES (?, ?, '', UTC_TIMESTAMP(), ?)`
+	result, err := tx.Exec(stmt, name, email, emailVerified)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return 0, ErrDuplicateEmail
+			}
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO user_identities (issuer, subject, user_id, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.Exec(stmt, issuer, subject, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// lockoutPolicy decides how long logins are refused after a number of consecutive failures
+type lockoutPolicy struct {
+	//failures allowed before each further failure adds a delay, doubling from one second
+	delayAfter int
+	//failures before a full lockout
+	lockAfter int
+	lockout   time.Duration
+	//failures older than this are forgotten
+	window time.Duration
+}
+
+var (
+	accountLockout = lockoutPolicy{delayAfter: 3, lockAfter: 10, lockout: 15 * time.Minute, window: time.Hour}
+	//an address can be shared by many people, so it gets more leeway than an account
+	ipLockout = lockoutPolicy{delayAfter: 20, lockAfter: 100, lockout: time.Hour, window: time.Hour}
+)
+
+// delay returns how long to refuse logins after the given number of consecutive failures
+func (p lockoutPolicy) delay(failures int) time.Duration {
+	if failures >= p.lockAfter {
+		return p.lockout
+	}
+	if failures > p.delayAfter {
+		return min(time.Second<<(failures-p.delayAfter-1), p.lockout)
+	}
+	return 0
+}
+
+// next returns the failure count after another failure at now, given the previous
+// count and when the last failure happened, and until when logins are refused
+func (p lockoutPolicy) next(failures int, last sql.NullTime, now time.Time) (int, sql.NullTime) {
+	if !last.Valid || now.Sub(last.Time) > p.window {
+		failures = 0
+	}
+	failures++
+
+	d := p.delay(failures)
+	if d == 0 {
+		return failures, sql.NullTime{}
+	}
+	return failures, sql.NullTime{Time: now.Add(d), Valid: true}
+}
+
+// LoginThrottleModel tracks failed logins per client IP address
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+// Check returns ErrLoginThrottled if logins from ip are currently refused
+func (m *LoginThrottleModel) Check(ip string) error {
+	var throttled bool
+	stmt := `SELECT EXISTS (SELECT true FROM login_failures WHERE ip = ? AND locked_until > UTC_TIMESTAMP())`
+	err := m.DB.QueryRow(stmt, ip).Scan(&throttled)
+	if err != nil {
+		return err
+	}
+	if throttled {
+		return ErrLoginThrottled
+	}
+	return nil
+}
+
+// RecordFailure This will count a failed login from ip, refusing further logins from it for a while once there are too many
+func (m *LoginThrottleModel) RecordFailure(ip string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var last sql.NullTime
+	var now time.Time
+	stmt := `SELECT failures, last_failure, UTC_TIMESTAMP() FROM login_failures WHERE ip = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, ip).Scan(&failures, &last, &now)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRow(`SELECT UTC_TIMESTAMP()`).Scan(&now)
+	}
+	if err != nil {
+		return err
+	}
+
+	failures, lockedUntil := ipLockout.next(failures, last, now)
+
+	stmt = `INSERT INTO login_failures (ip, failures, last_failure, locked_until) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE failures = VALUES(failures), last_failure = VALUES(last_failure), locked_until = VALUES(locked_until)`
+	_, err = tx.Exec(stmt, ip, failures, now, lockedUntil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteStale removes addresses whose failures have been forgotten and that aren't locked
+func (m *LoginThrottleModel) DeleteStale() error {
+	stmt := `DELETE FROM login_failures
+			 WHERE last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+			 AND (locked_until IS NULL OR locked_until < UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, int(ipLockout.window.Seconds()))
+	return err
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user. Credential is opaque to
+// this package, it's whatever the web application needs to verify assertions.
+type Passkey struct {
+	ID           int
+	UserID       int
+	Name         string
+	CredentialID []byte
+	Credential   []byte
+	SignCount    uint32
+	Created      time.Time
+	LastUsed     time.Time
+}
+
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+// Insert This will register a new passkey for the user
+func (m *PasskeyModel) Insert(userID int, name string, credentialID, credential []byte, signCount uint32) error {
+	stmt := `INSERT INTO passkeys (user_id, name, credential_id, credential, sign_count, created)
+			 VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, userID, name, credentialID, credential, signCount)
+	return err
+}
+
+// ForUser returns the user's passkeys, oldest first
+func (m *PasskeyModel) ForUser(userID int) ([]*Passkey, error) {
+	stmt := `SELECT id, user_id, name, credential_id, credential, sign_count, created, last_used
+			 FROM passkeys WHERE user_id = ? ORDER BY id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+// GetByCredentialID returns the passkey with the given WebAuthn credential id
+func (m *PasskeyModel) GetByCredentialID(credentialID []byte) (*Passkey, error) {
+	stmt := `SELECT id, user_id, name, credential_id, credential, sign_count, created, last_used
+			 FROM passkeys WHERE credential_id = ?`
+
+	p, err := scanPasskey(m.DB.QueryRow(stmt, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// Use records a successful login with the passkey. The signature counter has to go up
+// with every use, unless the authenticator doesn't keep one and always sends zero.
+// Otherwise it returns ErrInvalidCredentials, since the passkey may have been cloned
+// or the assertion replayed.
+func (m *PasskeyModel) Use(id int, credential []byte, signCount uint32) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//the row lock stops two logins with the same counter value racing each other
+	var stored uint32
+	err = tx.QueryRow(`SELECT sign_count FROM passkeys WHERE id = ? FOR UPDATE`, id).Scan(&stored)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if signCount <= stored && (signCount != 0 || stored != 0) {
+		return ErrInvalidCredentials
+	}
+
+	stmt := `UPDATE passkeys SET credential = ?, sign_count = ?, last_used = UTC_TIMESTAMP() WHERE id = ?`
+	_, err = tx.Exec(stmt, credential, signCount, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes one of the user's passkeys
+func (m *PasskeyModel) Delete(id, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM passkeys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+func scanPasskey(row rowScanner) (*Passkey, error) {
+	p := &Passkey{}
+	var lastUsed sql.NullTime
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.Credential, &p.SignCount, &p.Created, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	p.LastUsed = lastUsed.Time
+	return p, nil
+}
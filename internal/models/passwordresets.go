@@ -0,0 +1,87 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// New generates a reset token for the user that is valid for ttl and returns it in plain text, only its hash is stored.
+func (m *PasswordResetModel) New(userID int, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	//tidy up expired tokens while we're here
+	_, err := m.DB.Exec(`DELETE FROM password_resets WHERE expires < UTC_TIMESTAMP()`)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO password_resets (token_hash, user_id, expires)
+			 VALUES (?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.Exec(stmt, hashResetToken(token), userID, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UserID returns the id of the user the token was issued to, or ErrInvalidToken if it has expired or been used.
+func (m *PasswordResetModel) UserID(token string) (int, error) {
+	var userID int
+	stmt := `SELECT user_id FROM password_resets WHERE token_hash = ? AND expires > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, hashResetToken(token)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Consume This will use up the token, along with any others issued to the same user, and return the user's id
+func (m *PasswordResetModel) Consume(token string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	//the row lock stops the same token being used twice at once
+	var userID int
+	stmt := `SELECT user_id FROM password_resets WHERE token_hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashResetToken(token)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
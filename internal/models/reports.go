@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Report states.
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+)
+
+// Report is an abuse report about a snippet, made by a user or an anonymous visitor.
+type Report struct {
+	ID           int
+	SnippetID    int
+	SnippetTitle string
+	ReporterID   int
+	ReporterIP   string
+	Reason       string
+	Comment      string
+	Status       string
+	Created      time.Time
+}
+
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// Insert files a new open report. A zero reporterID records an anonymous report.
+func (m *ReportModel) Insert(snippetID, reporterID int, reporterIP, reason, comment string) error {
+	stmt := `INSERT INTO reports (snippet_id, reporter_id, reporter_ip, reason, comment, status, created)
+			 VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, snippetID, nullableID(reporterID), reporterIP, reason, comment, ReportOpen)
+	return err
+}
+
+// CountRecentByIP returns how many reports were made from the IP address within the window.
+func (m *ReportModel) CountRecentByIP(ip string, window time.Duration) (int, error) {
+	var n int
+	stmt := `SELECT COUNT(*) FROM reports WHERE reporter_ip = ? AND created > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+	err := m.DB.QueryRow(stmt, ip, int(window.Seconds())).Scan(&n)
+	return n, err
+}
+
+// Open returns the open reports, oldest first, which is the moderation queue.
+func (m *ReportModel) Open(limit int) ([]*Report, error) {
+	stmt := `SELECT r.id, r.snippet_id, s.title, r.reporter_id, r.reporter_ip, r.reason, r.comment, r.status, r.created
+			 FROM reports r
+			 INNER JOIN snippets s ON s.id = r.snippet_id
+			 WHERE r.status = ?
+			 ORDER BY r.id ASC
+			 LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, ReportOpen, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+	for rows.Next() {
+		r := &Report{}
+		var reporterID sql.NullInt64
+
+		err := rows.Scan(&r.ID, &r.SnippetID, &r.SnippetTitle, &reporterID, &r.ReporterIP, &r.Reason, &r.Comment, &r.Status, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		r.ReporterID = int(reporterID.Int64)
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// Dismiss closes a single open report without acting on the snippet.
+func (m *ReportModel) Dismiss(id, moderatorID int) error {
+	stmt := `UPDATE reports SET status = ?, resolved_by = ?, resolved = UTC_TIMESTAMP() WHERE id = ? AND status = ?`
+
+	result, err := m.DB.Exec(stmt, ReportDismissed, moderatorID, id, ReportOpen)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// HideSnippet hides a reported snippet and closes its open reports in a single transaction,
+// so the reports stay open if the snippet isn't hidden. Hiding a snippet that is already
+// hidden just closes the reports made since.
+func (m *ReportModel) HideSnippet(snippetID, moderatorID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hidden bool
+	err = tx.QueryRow(`SELECT hidden FROM snippets WHERE id = ? FOR UPDATE`, snippetID).Scan(&hidden)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE snippets SET hidden = TRUE WHERE id = ?`, snippetID); err != nil {
+		return err
+	}
+
+	stmt := `UPDATE reports SET status = ?, resolved_by = ?, resolved = UTC_TIMESTAMP() WHERE snippet_id = ? AND status = ?`
+	if _, err := tx.Exec(stmt, ReportActioned, moderatorID, snippetID, ReportOpen); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,26 @@
+package models
+
+// User roles, each role can do everything the roles before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every role from least to most privileged.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// HasRole reports whether a user with the given role is allowed to do what
+// the required role can. Unknown roles have no privileges.
+func HasRole(role, required string) bool {
+	return roleRank(role) >= roleRank(required) && roleRank(required) > 0
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+)
+
+type SigningKeyModel struct {
+	DB *sql.DB
+}
+
+// Get returns the key with the given name, generating a random key of size bytes the first
+// time. When several instances start at once only the first key stored is used by all of them.
+func (m *SigningKeyModel) Get(name string, size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT IGNORE INTO signing_keys (name, value, created) VALUES (?, ?, UTC_TIMESTAMP())`
+	if _, err := m.DB.Exec(stmt, name, key); err != nil {
+		return nil, err
+	}
+
+	err := m.DB.QueryRow(`SELECT value FROM signing_keys WHERE name = ?`, name).Scan(&key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -9,10 +9,15 @@ import (
 // Snippet Define a Snippet type to hold the data for an individual snippet.
 type Snippet struct {
 	ID      int
+	UserID  int
 	Title   string
 	Content string
 	Created time.Time
 	Expires time.Time
+	// Hidden snippets have been hidden by a moderator and are only visible to their owner.
+	Hidden bool
+	// OwnerName is only filled in by the queries that join the users table.
+	OwnerName string
 }
 
 // SnippetModel Define a SnippetModel type which wraps a sql.DB connection pool.
@@ -20,12 +25,12 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires) 
-			VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// Insert This will insert a new snippet into the database, owned by the given user.
+func (m *SnippetModel) Insert(title string, content string, expires int, userID int) (int, error) {
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id) 
+			VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?)`
 
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := m.DB.Exec(stmt, title, content, expires, nullableID(userID))
 	if err != nil {
 		return 0, err
 	}
@@ -38,17 +43,63 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 	return int(id), nil
 }
 
-// Get This will return a specific snippet based on its id.
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires 
-			 FROM snippets
-			 WHERE expires > UTC_TIMESTAMP() AND id = ?`
+// NewSnippet holds the fields needed to insert a snippet in bulk.
+type NewSnippet struct {
+	Title   string
+	Content string
+	Expires int
+}
+
+// InsertAll This will insert all the snippets for the given user in a single
+// transaction and return their ids in the same order. If any insert fails
+// nothing is stored.
+func (m *SnippetModel) InsertAll(snippets []NewSnippet, userID int) ([]int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
 
-	row := m.DB.QueryRow(stmt, id)
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
 
-	s := &Snippet{}
+	stmt, err := tx.Prepare(`INSERT INTO snippets (title, content, created, expires, user_id) 
+			VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	ids := make([]int, 0, len(snippets))
+
+	for _, s := range snippets {
+		result, err := stmt.Exec(s.Title, s.Content, s.Expires, nullableID(userID))
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int(id))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Get This will return a specific snippet based on its id. Hidden snippets are
+// only returned to their owner, anyone else gets ErrNoRecord.
+func (m *SnippetModel) Get(id int, userID int) (*Snippet, error) {
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden 
+			 FROM snippets
+			 WHERE expires > UTC_TIMESTAMP() AND id = ? AND (hidden = FALSE OR user_id = ?)`
 
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	row := m.DB.QueryRow(stmt, id, userID)
+
+	s, err := scanSnippet(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -61,12 +112,26 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 
 // Latest This will return the 10 most recently created snippets.
 func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden
 			 FROM snippets
-			 WHERE expires > UTC_TIMESTAMP()
+			 WHERE expires > UTC_TIMESTAMP() AND hidden = FALSE
 			 ORDER BY id DESC LIMIT 10`
 
-	rows, err := m.DB.Query(stmt)
+	return m.query(stmt)
+}
+
+// LatestByUser This will return the 10 most recently created snippets owned by the user.
+func (m *SnippetModel) LatestByUser(userID int) ([]*Snippet, error) {
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden
+			 FROM snippets
+			 WHERE expires > UTC_TIMESTAMP() AND user_id = ? AND hidden = FALSE
+			 ORDER BY id DESC LIMIT 10`
+
+	return m.query(stmt, userID)
+}
+
+func (m *SnippetModel) query(stmt string, args ...any) ([]*Snippet, error) {
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -76,8 +141,7 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	snippets := []*Snippet{}
 
 	for rows.Next() {
-		s := &Snippet{}
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -89,3 +153,152 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	}
 	return snippets, nil
 }
+
+// Delete This will delete a snippet, as long as it is owned by the given user.
+func (m *SnippetModel) Delete(id int, userID int) error {
+	stmt := `DELETE FROM snippets WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Expired This will return the snippets that have expired but haven't been purged yet, oldest first.
+func (m *SnippetModel) Expired() ([]*Snippet, error) {
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden
+			 FROM snippets
+			 WHERE expires <= UTC_TIMESTAMP()
+			 ORDER BY expires ASC`
+
+	return m.query(stmt)
+}
+
+// PurgeExpired This will permanently delete all expired snippets and return how many were deleted.
+func (m *SnippetModel) PurgeExpired() (int, error) {
+	result, err := m.DB.Exec(`DELETE FROM snippets WHERE expires <= UTC_TIMESTAMP()`)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Counts This will return the number of active and expired snippets.
+func (m *SnippetModel) Counts() (active, expired int, err error) {
+	stmt := `SELECT COALESCE(SUM(expires > UTC_TIMESTAMP()), 0), COALESCE(SUM(expires <= UTC_TIMESTAMP()), 0) FROM snippets`
+	err = m.DB.QueryRow(stmt).Scan(&active, &expired)
+	return active, expired, err
+}
+
+// Recent This will return the most recently created snippets including expired ones,
+// along with the names of their owners.
+func (m *SnippetModel) Recent(limit int) ([]*Snippet, error) {
+	stmt := `SELECT s.id, s.user_id, s.title, s.created, s.expires, s.hidden, COALESCE(u.name, '')
+			 FROM snippets s
+			 LEFT JOIN users u ON u.id = s.user_id
+			 ORDER BY s.id DESC
+			 LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		var userID sql.NullInt64
+
+		err := rows.Scan(&s.ID, &userID, &s.Title, &s.Created, &s.Expires, &s.Hidden, &s.OwnerName)
+		if err != nil {
+			return nil, err
+		}
+
+		s.UserID = int(userID.Int64)
+		snippets = append(snippets, s)
+	}
+	return snippets, rows.Err()
+}
+
+// Expire This will make a snippet expire immediately, whoever owns it.
+func (m *SnippetModel) Expire(id int) error {
+	result, err := m.DB.Exec(`UPDATE snippets SET expires = UTC_TIMESTAMP() WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// AcknowledgeSecrets This will record that the author chose to publish a snippet
+// even though it looks like it contains credentials.
+func (m *SnippetModel) AcknowledgeSecrets(id int) error {
+	result, err := m.DB.Exec(`UPDATE snippets SET secrets_acknowledged = UTC_TIMESTAMP() WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// DeleteAny This will delete a snippet, whoever owns it.
+func (m *SnippetModel) DeleteAny(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// EachByUser This will call fn for every unexpired snippet owned by the user,
+// oldest first. Rows are read one at a time so callers can stream the results.
+func (m *SnippetModel) EachByUser(userID int, fn func(*Snippet) error) error {
+	stmt := `SELECT id, user_id, title, content, created, expires, hidden
+			 FROM snippets
+			 WHERE expires > UTC_TIMESTAMP() AND user_id = ?
+			 ORDER BY id ASC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSnippet(row rowScanner) (*Snippet, error) {
+	s := &Snippet{}
+	var userID sql.NullInt64
+
+	err := row.Scan(&s.ID, &userID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Hidden)
+	if err != nil {
+		return nil, err
+	}
+
+	s.UserID = int(userID.Int64)
+	return s, nil
+}
+
+// nullableID stores a zero id as NULL, e.g. for snippets without an owner.
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// prefix of every API token, which makes leaked tokens easy to spot
+const apiTokenPrefix = "sbx_"
+
+// APIToken is a personal access token used by API clients. The token itself
+// is only known when it is created.
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+type APITokenModel struct {
+	DB *sql.DB
+}
+
+// New generates a token for the user and returns it in plain text, only its hash is stored.
+func (m *APITokenModel) New(userID int, name string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO api_tokens (user_id, name, token_hash, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, userID, name, hashAPIToken(token))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Authenticate returns the id of the user the token belongs to, and records that it was used.
+func (m *APITokenModel) Authenticate(token string) (int, error) {
+	var id, userID int
+
+	stmt := `SELECT t.id, t.user_id
+			 FROM api_tokens t
+			 INNER JOIN users u ON u.id = t.user_id
+			 WHERE t.token_hash = ? AND u.disabled = FALSE`
+	err := m.DB.QueryRow(stmt, hashAPIToken(token)).Scan(&id, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	_, err = m.DB.Exec(`UPDATE api_tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// ForUser returns the user's tokens, newest first.
+func (m *APITokenModel) ForUser(userID int) ([]*APIToken, error) {
+	stmt := `SELECT id, user_id, name, created, last_used FROM api_tokens WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*APIToken{}
+	for rows.Next() {
+		t := &APIToken{}
+		var lastUsed sql.NullTime
+
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Created, &lastUsed); err != nil {
+			return nil, err
+		}
+
+		t.LastUsed = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Delete revokes one of the user's tokens.
+func (m *APITokenModel) Delete(id, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// number of recovery codes a user gets when they enable two-factor authentication
+const recoveryCodeCount = 10
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Secret returns the user's TOTP secret, or ErrNoRecord if they haven't enabled two-factor authentication
+func (m *TwoFactorModel) Secret(userID int) (string, error) {
+	var secret sql.NullString
+	err := m.DB.QueryRow(`SELECT totp_secret FROM users WHERE id = ?`, userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+	if !secret.Valid {
+		return "", ErrNoRecord
+	}
+	return secret.String, nil
+}
+
+// Enabled reports whether the user has two-factor authentication turned on
+func (m *TwoFactorModel) Enabled(userID int) (bool, error) {
+	var enabled bool
+	stmt := `SELECT EXISTS (SELECT true FROM users WHERE id = ? AND totp_secret IS NOT NULL)`
+	err := m.DB.QueryRow(stmt, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Enable This will turn on two-factor authentication with secret and return a fresh set of recovery codes in
+// plain text, only their hashes are stored. step is the time step of the code that confirmed the secret.
+// It returns ErrNoRecord if two-factor authentication is on already, replacing the secret takes turning it off first.
+func (m *TwoFactorModel) Enable(userID int, secret string, step int64) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET totp_secret = ?, totp_last_step = ? WHERE id = ? AND totp_secret IS NULL`, secret, step, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRowsAffected(result); err != nil {
+		return nil, err
+	}
+
+	if err := insertRecoveryCodes(tx, userID, codes); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication and deletes the user's recovery codes
+func (m *TwoFactorModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseStep records that the user's code for the given time step has been used. It returns
+// ErrInvalidCredentials if that code, or a later one, has been used already.
+func (m *TwoFactorModel) UseStep(userID int, step int64) error {
+	stmt := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+	result, err := m.DB.Exec(stmt, step, userID, step)
+	if err != nil {
+		return err
+	}
+	if err := checkRowsAffected(result); err != nil {
+		if errors.Is(err, ErrNoRecord) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return nil
+}
+
+// UseRecoveryCode This will use up one of the user's recovery codes and return how many are left,
+// or ErrInvalidCredentials if the code doesn't match any of them
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) (int, error) {
+	stmt := `DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?`
+	result, err := m.DB.Exec(stmt, userID, hashRecoveryCode(code))
+	if err != nil {
+		return 0, err
+	}
+	if err := checkRowsAffected(result); err != nil {
+		if errors.Is(err, ErrNoRecord) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	var left int
+	err = m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`, userID).Scan(&left)
+	return left, err
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes and returns the new ones in plain text
+func (m *TwoFactorModel) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertRecoveryCodes(tx, userID, codes); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// insertRecoveryCodes replaces the user's recovery codes with codes
+func insertRecoveryCodes(tx *sql.Tx, userID int, codes []string) error {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns a set of codes like "k7d2q-x9mfa", leaving out characters that are easily confused
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		//ten characters of five bits each
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes code ignoring case, spaces and dashes, so it can be typed however it was written down
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -4,7 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
+	"snippetbox.rakesh.net/internal/passhash"
 	"strings"
 	"time"
 )
@@ -15,40 +15,77 @@ type User struct {
 	Email          string
 	HashedPassword string
 	Created        time.Time
+	Disabled       bool
+	Role           string
+	LockedUntil    time.Time
+	EmailVerified  bool
+}
+
+// Locked reports whether logins to the account are currently refused after failed attempts
+func (u *User) Locked() bool {
+	return u.LockedUntil.After(time.Now())
 }
 
 type UserModel struct {
 	DB *sql.DB
+	//hashes passwords, passhash.Default when nil
+	Hasher *passhash.Hasher
+}
+
+func (m *UserModel) hasher() *passhash.Hasher {
+	if m.Hasher == nil {
+		return passhash.Default
+	}
+	return m.Hasher
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+// Insert This will create an unverified user and return its id
+func (m *UserModel) Insert(name, email, password string) (int, error) {
+	hashedPassword, err := m.hasher().Hash(password)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO  users (name, email, hashed_password, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, hashedPassword)
 	if err != nil {
 		var mySQLError *mysql.MySQLError
 
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
 			}
 		}
-		return err
+		return 0, err
 	}
-	return nil
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
 }
 
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	var id int
-	var hashedPassword []byte
+	var hashedPassword string
+	var disabled, twoFactor bool
+	var failures int
+	var lastFailure, lockedUntil sql.NullTime
+	var now time.Time
 
-	stmt := `SELECT id, hashed_password FROM users WHERE email = ?`
+	//the row stays locked until the failure is recorded, otherwise guesses made at the same time
+	//would all read the same count and get past the lockout
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id, hashed_password, disabled, totp_secret IS NOT NULL, failed_logins, last_failed_login, locked_until, UTC_TIMESTAMP()
+			 FROM users WHERE email = ? FOR UPDATE`
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err = tx.QueryRow(stmt, email).Scan(&id, &hashedPassword, &disabled, &twoFactor, &failures, &lastFailure, &lockedUntil, &now)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -57,22 +94,348 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		}
 	}
 
-	//checking the hashed and plain-text passwords
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	//refuse without checking the password, otherwise guessing could carry on during the lockout
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		return 0, ErrAccountLocked
+	}
+
+	//checking the hashed and plain-text passwords, accounts created by single sign-on don't have one and never match
+	match, rehash, err := m.hasher().Verify(hashedPassword, password)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
-		} else {
+		return 0, err
+	}
+	if !match {
+		if err := recordFailedLogin(tx, id, failures, lastFailure, now); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return 0, ErrInvalidCredentials
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	//the plain-text password is only available now, so this is when hashes from an older algorithm or cost get upgraded
+	if rehash {
+		if err := m.rehash(id, hashedPassword, password); err != nil {
+			return 0, err
+		}
+	}
+
+	//with two-factor authentication on, the failures are only forgotten once the code is right too,
+	//otherwise the password would reset the count of wrong codes
+	if failures > 0 && !twoFactor {
+		if err := m.Unlock(id); err != nil {
 			return 0, err
 		}
 	}
 
+	//only tell the user the account is disabled once they have proven they own it
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
 	return id, nil
 }
 
+// RecordFailedLogin counts a failed login against the user, such as a wrong two-factor code
+// after the right password, so it adds to the same lockout as wrong passwords
+func (m *UserModel) RecordFailedLogin(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var lastFailure sql.NullTime
+	var now time.Time
+	stmt := `SELECT failed_logins, last_failed_login, UTC_TIMESTAMP() FROM users WHERE id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&failures, &lastFailure, &now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if err := recordFailedLogin(tx, id, failures, lastFailure, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordFailedLogin adds a failure at now to the count read from the user's locked row
+func recordFailedLogin(tx *sql.Tx, id, failures int, lastFailure sql.NullTime, now time.Time) error {
+	failures, lockedUntil := accountLockout.next(failures, lastFailure, now)
+	stmt := `UPDATE users SET failed_logins = ?, last_failed_login = ?, locked_until = ? WHERE id = ?`
+	_, err := tx.Exec(stmt, failures, now, lockedUntil, id)
+	return err
+}
+
+// Get returns the user with the given id
+func (m *UserModel) Get(id int) (*User, error) {
+	stmt := `SELECT id, name, email, created, disabled, role, locked_until, email_verified FROM users WHERE id = ?`
+	u, err := scanUser(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
 func (m *UserModel) Exists(id int) (bool, error) {
 	var exists bool
-	stmt := "SELECT EXISTS (SELECT true FROM users WHERE id = ?)"
+	//disabled users are treated as if they didn't exist, which logs them out everywhere
+	stmt := "SELECT EXISTS (SELECT true FROM users WHERE id = ? AND disabled = FALSE)"
 	err := m.DB.QueryRow(stmt, id).Scan(&exists)
 	return exists, err
 }
+
+// IDByEmail returns the id of the user with the given email address
+func (m *UserModel) IDByEmail(email string) (int, error) {
+	var id int
+	stmt := `SELECT id FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// SetDisabled disables or re-enables the user with the given id
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	result, err := m.DB.Exec(`UPDATE users SET disabled = ? WHERE id = ?`, disabled, id)
+	if err != nil {
+		return err
+	}
+	return m.checkUpdated(result, id)
+}
+
+// SetPassword replaces the user's password
+func (m *UserModel) SetPassword(id int, password string) error {
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.DB.Exec(`UPDATE users SET hashed_password = ? WHERE id = ?`, hashedPassword, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// rehash replaces the hash of the user's password with one using the current algorithm and
+// parameters, unless the password has been changed since old was read
+func (m *UserModel) rehash(id int, old, password string) error {
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.Exec(`UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`, hashedPassword, id, old)
+	return err
+}
+
+// VerifyEmail marks the user's email address as verified, as long as it is still email
+func (m *UserModel) VerifyEmail(id int, email string) error {
+	result, err := m.DB.Exec(`UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?`, id, email)
+	if err != nil {
+		return err
+	}
+	//verifying twice is fine, MySQL only counts rows that actually changed
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	var exists bool
+	err = m.DB.QueryRow(`SELECT EXISTS (SELECT true FROM users WHERE id = ? AND email = ?)`, id, email).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Unlock clears the failed logins of the user with the given id, lifting any lockout
+func (m *UserModel) Unlock(id int) error {
+	stmt := `UPDATE users SET failed_logins = 0, last_failed_login = NULL, locked_until = NULL WHERE id = ?`
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+	return m.checkUpdated(result, id)
+}
+
+// SetName changes the user's name
+func (m *UserModel) SetName(id int, name string) error {
+	_, err := m.DB.Exec(`UPDATE users SET name = ? WHERE id = ?`, name, id)
+	return err
+}
+
+// SetEmail changes the user's email address, which then needs verifying again
+func (m *UserModel) SetEmail(id int, email string) error {
+	_, err := m.DB.Exec(`UPDATE users SET email = ?, email_verified = FALSE WHERE id = ?`, email, id)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return ErrDuplicateEmail
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// CheckPassword returns ErrInvalidCredentials unless password is the user's current password,
+// accounts without a password never match
+func (m *UserModel) CheckPassword(id int, password string) error {
+	var hashedPassword string
+	err := m.DB.QueryRow(`SELECT hashed_password FROM users WHERE id = ?`, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	match, _, err := m.hasher().Verify(hashedPassword, password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// HasPassword This will report whether the user has a password, users created through
+// single sign-on don't until they set one
+func (m *UserModel) HasPassword(id int) (bool, error) {
+	var hasPassword bool
+	err := m.DB.QueryRow(`SELECT hashed_password <> '' FROM users WHERE id = ?`, id).Scan(&hasPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+	return hasPassword, nil
+}
+
+// Delete This will delete the user. Their snippets are deleted too if deleteSnippets is
+// true, otherwise they are kept without an owner.
+func (m *UserModel) Delete(id int, deleteSnippets bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//the foreign key sets user_id to NULL on the snippets that are kept
+	if deleteSnippets {
+		_, err = tx.Exec(`DELETE FROM snippets WHERE user_id = ?`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if err := checkRowsAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Counts returns the total number of users and how many of them are disabled
+func (m *UserModel) Counts() (total, disabled int, err error) {
+	stmt := `SELECT COUNT(*), COALESCE(SUM(disabled), 0) FROM users`
+	err = m.DB.QueryRow(stmt).Scan(&total, &disabled)
+	return total, disabled, err
+}
+
+// SetRole changes the role of the user with the given id
+func (m *UserModel) SetRole(id int, role string) error {
+	result, err := m.DB.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return err
+	}
+	return m.checkUpdated(result, id)
+}
+
+// checkUpdated is checkRowsAffected for updates that can leave the row as it was. MySQL
+// only counts rows that changed, so no rows is only ErrNoRecord if the user doesn't exist.
+func (m *UserModel) checkUpdated(result sql.Result, id int) error {
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	var exists bool
+	err = m.DB.QueryRow("SELECT EXISTS (SELECT true FROM users WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Search returns up to limit users whose name or email contains query, most
+// recently created first. An empty query matches every user.
+func (m *UserModel) Search(query string, limit int) ([]*User, error) {
+	stmt := `SELECT id, name, email, created, disabled, role, locked_until, email_verified
+			 FROM users
+			 WHERE name LIKE ? OR email LIKE ?
+			 ORDER BY id DESC
+			 LIMIT ?`
+
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	rows, err := m.DB.Query(stmt, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	u := &User{}
+	var lockedUntil sql.NullTime
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Disabled, &u.Role, &lockedUntil, &u.EmailVerified)
+	if err != nil {
+		return nil, err
+	}
+	u.LockedUntil = lockedUntil.Time
+	return u, nil
+}
+
+// likeEscaper escapes the wildcard characters of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
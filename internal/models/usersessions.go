@@ -0,0 +1,127 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+// how often the last seen time of a session is written, rather than on every request
+const sessionSeenInterval = time.Minute
+
+// UserSession is where a user is logged in
+type UserSession struct {
+	ID        string
+	UserID    int
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+	IP        string
+	UserAgent string
+}
+
+type UserSessionModel struct {
+	DB *sql.DB
+}
+
+// Insert This will record a new login for the user that lasts for ttl and return the session's id
+func (m *UserSessionModel) Insert(userID int, ip, userAgent string, ttl time.Duration) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	stmt := `INSERT INTO user_sessions (id, user_id, created, last_seen, expires, ip, user_agent)
+			 VALUES (?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), ?, ?)`
+	_, err := m.DB.Exec(stmt, id, userID, int(ttl.Seconds()), ip, truncate(userAgent, 255))
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Seen records a request from the session and returns ErrNoRecord if it has been
+// revoked or has expired, or doesn't belong to the user
+func (m *UserSessionModel) Seen(id string, userID int, ip, userAgent string) error {
+	var lastSeen, now time.Time
+	stmt := `SELECT last_seen, UTC_TIMESTAMP() FROM user_sessions WHERE id = ? AND user_id = ? AND expires > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, id, userID).Scan(&lastSeen, &now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if now.Sub(lastSeen) < sessionSeenInterval {
+		return nil
+	}
+
+	stmt = `UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ?, user_agent = ? WHERE id = ?`
+	_, err = m.DB.Exec(stmt, ip, truncate(userAgent, 255), id)
+	return err
+}
+
+// ForUser returns the user's unexpired sessions, most recently seen first. Sessions
+// unused for longer than idleTimeout have expired too, unless it's zero.
+func (m *UserSessionModel) ForUser(userID int, idleTimeout time.Duration) ([]*UserSession, error) {
+	stmt := `SELECT id, user_id, created, last_seen, expires, ip, user_agent
+			 FROM user_sessions
+			 WHERE user_id = ? AND expires > UTC_TIMESTAMP()
+			 AND (? = 0 OR last_seen > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND))
+			 ORDER BY last_seen DESC`
+
+	idle := int(idleTimeout.Seconds())
+	rows, err := m.DB.Query(stmt, userID, idle, idle)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+	for rows.Next() {
+		s := &UserSession{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expires, &s.IP, &s.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Delete revokes one of the user's sessions
+func (m *UserSessionModel) Delete(id string, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM user_sessions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// DeleteForUser revokes all of the user's sessions
+func (m *UserSessionModel) DeleteForUser(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM user_sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+// DeleteExpired removes sessions that scs has already expired
+func (m *UserSessionModel) DeleteExpired() error {
+	_, err := m.DB.Exec(`DELETE FROM user_sessions WHERE expires < UTC_TIMESTAMP()`)
+	return err
+}
+
+// truncate shortens s to at most n bytes, without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
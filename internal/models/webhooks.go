@@ -0,0 +1,231 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a subscription that receives signed POST requests for the listed events.
+type Webhook struct {
+	ID      int
+	UserID  int
+	URL     string
+	Secret  string
+	Events  []string
+	Created time.Time
+}
+
+// WebhookDelivery is a single queued or attempted delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID           int
+	WebhookID    int
+	URL          string
+	Secret       string
+	Event        string
+	Payload      string
+	Status       string
+	Attempts     int
+	NextAttempt  time.Time
+	ResponseCode int
+	LastError    string
+	Created      time.Time
+	Updated      time.Time
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a new webhook subscription for the user.
+func (m *WebhookModel) Insert(userID int, url, secret string, events []string) (int, error) {
+	stmt := `INSERT INTO webhooks (user_id, url, secret, events, created) VALUES (?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, userID, url, secret, strings.Join(events, ","))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Get returns a webhook, as long as it belongs to the user.
+func (m *WebhookModel) Get(id, userID int) (*Webhook, error) {
+	stmt := `SELECT id, user_id, url, secret, events, created FROM webhooks WHERE id = ? AND user_id = ?`
+
+	w, err := scanWebhook(m.DB.QueryRow(stmt, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return w, nil
+}
+
+// ForUser returns all the webhooks owned by the user.
+func (m *WebhookModel) ForUser(userID int) ([]*Webhook, error) {
+	stmt := `SELECT id, user_id, url, secret, events, created FROM webhooks WHERE user_id = ? ORDER BY id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// Delete removes a webhook owned by the user, along with its deliveries.
+func (m *WebhookModel) Delete(id, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Enqueue queues a delivery of the payload to every webhook of the user that is subscribed to the event.
+func (m *WebhookModel) Enqueue(userID int, event string, payload []byte) error {
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt, created, updated)
+			 SELECT id, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), UTC_TIMESTAMP()
+			 FROM webhooks
+			 WHERE user_id = ? AND FIND_IN_SET(?, events) > 0`
+
+	_, err := m.DB.Exec(stmt, event, string(payload), DeliveryPending, userID, event)
+	return err
+}
+
+// Claim returns up to limit pending deliveries that are due, and leases them
+// for the given duration so that other instances don't pick them up as well.
+func (m *WebhookModel) Claim(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT d.id, d.webhook_id, w.url, w.secret, d.event, d.payload, d.attempts
+			 FROM webhook_deliveries d
+			 INNER JOIN webhooks w ON w.id = d.webhook_id
+			 WHERE d.status = ? AND d.next_attempt <= UTC_TIMESTAMP()
+			 ORDER BY d.next_attempt
+			 LIMIT ?
+			 FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(stmt, DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Event, &d.Payload, &d.Attempts)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, d := range deliveries {
+		_, err := tx.Exec(`UPDATE webhook_deliveries SET next_attempt = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND) WHERE id = ?`,
+			int(lease.Seconds()), d.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt stores the outcome of a delivery attempt. A zero retryIn marks
+// the delivery as finished with the given status, otherwise it is retried after retryIn.
+func (m *WebhookModel) RecordAttempt(id int, status string, responseCode int, lastError string, retryIn time.Duration) error {
+	stmt := `UPDATE webhook_deliveries
+			 SET status = ?, attempts = attempts + 1, response_code = ?, last_error = ?,
+			     next_attempt = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), updated = UTC_TIMESTAMP()
+			 WHERE id = ?`
+
+	if len(lastError) > 1024 {
+		lastError = lastError[:1024]
+	}
+
+	_, err := m.DB.Exec(stmt, status, nullableID(responseCode), sql.NullString{String: lastError, Valid: lastError != ""},
+		int(retryIn.Seconds()), id)
+	return err
+}
+
+// Deliveries returns the most recent deliveries of a webhook, newest first.
+func (m *WebhookModel) Deliveries(webhookID int, limit int) ([]*WebhookDelivery, error) {
+	stmt := `SELECT id, webhook_id, event, payload, status, attempts, next_attempt, response_code, last_error, created, updated
+			 FROM webhook_deliveries
+			 WHERE webhook_id = ?
+			 ORDER BY id DESC
+			 LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		var responseCode sql.NullInt64
+		var lastError sql.NullString
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttempt,
+			&responseCode, &lastError, &d.Created, &d.Updated)
+		if err != nil {
+			return nil, err
+		}
+
+		d.ResponseCode = int(responseCode.Int64)
+		d.LastError = lastError.String
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	w := &Webhook{}
+	var events string
+
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Events = strings.Split(events, ",")
+	return w, nil
+}
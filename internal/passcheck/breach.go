@@ -0,0 +1,58 @@
+package passcheck
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList looks passwords up in a local copy of a breached password corpus,
+// split by SHA-1 hash prefix in the format of the Have I Been Pwned range API.
+// Dir holds one file per five character prefix, named after the prefix with an
+// optional .txt extension, e.g. 5BAA6 or 5BAA6.txt. Each line of a file is the
+// rest of a hash and the number of times it was seen, e.g.
+//
+//	1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493
+//
+// Prefixes without a file have no breached passwords.
+type BreachList struct {
+	Dir string
+}
+
+// Contains reports whether password appears in the list.
+func (b *BreachList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := b.open(prefix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (b *BreachList) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(b.Dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.Open(filepath.Join(b.Dir, prefix+".txt"))
+	}
+	return f, err
+}
@@ -0,0 +1,326 @@
+// Package passcheck estimates how easy passwords are to guess, and checks them
+// against a local list of passwords known from data breaches.
+package passcheck
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Score ranges from 0 (trivial to guess) to 4 (strong).
+const (
+	ScoreTooGuessable = iota
+	ScoreVeryGuessable
+	ScoreSomewhatGuessable
+	ScoreSafelyUnguessable
+	ScoreVeryUnguessable
+)
+
+// estimated bits of entropy needed for each score above 0
+var scoreBits = [...]float64{20, 30, 40, 55}
+
+// Result is the estimated strength of a password. Warning explains the weakest
+// part of a password that scores below ScoreVeryUnguessable, if there is one.
+type Result struct {
+	Score   int
+	Bits    float64
+	Warning string
+}
+
+// Warnings
+const (
+	WarningCommonWord = "it's based on a common word or password"
+	WarningUserInput  = "it contains your name or email address"
+	WarningSequence   = "it contains a sequence like abc, 123 or qwerty"
+	WarningRepeat     = "it contains repeated characters"
+	WarningYear       = "it contains a year"
+)
+
+//go:embed words.txt
+var wordList string
+
+// words are common passwords, English words and names, lower case, mapped to their rank from the most
+// common at 1. They come from the frequency lists of zxcvbn (MIT licensed), interleaved by each list's own ranking.
+var words = loadWords(wordList)
+
+// length in runes of the longest word, nothing longer is looked up
+var maxWordLen = longest(words)
+
+// sequences are alphabets and keyboard rows, runs along them are easy to guess in either direction
+var sequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"01234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+	"1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik9ol0p",
+}
+
+// leet undoes common character substitutions before dictionary lookups
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+// minimum length of the words, user inputs, sequences and repeats that are matched
+const minMatch = 3
+
+// longest returns the length in runes of the longest key of m
+func longest[V any](m map[string]V) int {
+	n := 0
+	for k := range m {
+		n = max(n, utf8.RuneCountInString(k))
+	}
+	return n
+}
+
+func loadWords(list string) map[string]int {
+	m := map[string]int{}
+	for _, w := range strings.Fields(list) {
+		w = strings.ToLower(w)
+		if _, ok := m[w]; !ok && len(w) >= minMatch {
+			m[w] = len(m) + 1
+		}
+	}
+	return m
+}
+
+// Estimate returns the strength of password. userInputs are things an attacker
+// would try first, such as the user's name and email address. The time it takes
+// grows with the square of the password's length, so callers should limit it.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	unleet := []rune(leet.Replace(strings.ToLower(password)))
+	//the replacements are all one rune for one rune, so positions line up
+	if len(unleet) != len(lower) {
+		unleet = lower
+	}
+
+	inputs := userInputTokens(userInputs)
+	maxLen := max(maxWordLen, longest(inputs))
+	perChar := math.Log2(float64(poolSize(runes)))
+
+	var bits, worstSaving float64
+	var warning string
+
+	for i := 0; i < len(runes); {
+		n, b, w := bestMatch(runes, lower, unleet, i, inputs, maxLen)
+		if n == 0 {
+			bits += perChar
+			i++
+			continue
+		}
+
+		//warn about whichever pattern saved the attacker the most guesses
+		if saving := float64(n)*perChar - b; saving > worstSaving {
+			worstSaving, warning = saving, w
+		}
+		bits += b
+		i += n
+	}
+
+	r := Result{Bits: bits}
+	for _, min := range scoreBits {
+		if bits >= min {
+			r.Score++
+		}
+	}
+	if r.Score < ScoreVeryUnguessable {
+		r.Warning = warning
+	}
+	return r
+}
+
+// bestMatch finds the longest pattern starting at i and returns its length, its
+// estimated bits of entropy and the warning it deserves, or a zero length if there isn't one.
+// No word or user input is longer than maxLen.
+func bestMatch(runes, lower, unleet []rune, i int, inputs map[string]bool, maxLen int) (int, float64, string) {
+	var n int
+	var bits float64
+	var warning string
+
+	try := func(length int, b float64, w string) {
+		if length >= minMatch && length > n {
+			n, bits, warning = length, b, w
+		}
+	}
+
+	for j := min(len(runes), i+maxLen); j-i >= minMatch; j-- {
+		word := string(lower[i:j])
+		plain := string(unleet[i:j])
+
+		caps := 0.0
+		if hasUpper(runes[i:j]) {
+			caps = 1
+		}
+		substituted := 0.0
+		if word != plain {
+			substituted = 1
+		}
+
+		if inputs[word] || inputs[plain] {
+			try(j-i, 1+caps+substituted, WarningUserInput)
+		}
+		//an attacker tries the most common words first, so a word is worth the guesses up to its rank
+		if rank := wordRank(word, plain); rank > 0 {
+			try(j-i, math.Log2(float64(rank))+caps+substituted, WarningCommonWord)
+		}
+	}
+
+	if length := sequenceLength(lower, i); length > 0 {
+		try(length, 2+math.Log2(float64(length)), WarningSequence)
+	}
+	if isYear(lower, i) {
+		//years from 1900 to 2099
+		try(4, math.Log2(200), WarningYear)
+	}
+	if length := repeatLength(lower, i); length > 0 {
+		try(length, 2+math.Log2(float64(length)), WarningRepeat)
+	}
+
+	return n, bits, warning
+}
+
+// wordRank returns the better rank of word and its substituted form plain, or 0 if neither is a word
+func wordRank(word, plain string) int {
+	rank, ok := words[word]
+	if r, found := words[plain]; found && (!ok || r < rank) {
+		rank = r
+	}
+	return rank
+}
+
+// sequenceLength returns the length of the run along one of the sequences starting at i
+func sequenceLength(s []rune, i int) int {
+	best := 0
+	for _, seq := range sequences {
+		for _, dir := range []int{1, -1} {
+			n := 1
+			for i+n < len(s) && follows(seq, s[i+n-1], s[i+n], dir) {
+				n++
+			}
+			if n >= minMatch && n > best {
+				best = n
+			}
+		}
+	}
+	return best
+}
+
+// follows reports whether b comes straight after a in seq, going in direction dir
+func follows(seq string, a, b rune, dir int) bool {
+	idx := strings.IndexRune(seq, a)
+	for idx >= 0 {
+		next := idx + dir
+		if next >= 0 && next < len(seq) && rune(seq[next]) == b {
+			return true
+		}
+		//a character can appear more than once, e.g. 0 at both ends of the digits
+		rest := strings.IndexRune(seq[idx+1:], a)
+		if rest < 0 {
+			break
+		}
+		idx += rest + 1
+	}
+	return false
+}
+
+// repeatLength returns the length of the repeated character, or repeated group such as
+// abcabc, starting at i
+func repeatLength(s []rune, i int) int {
+	best := 0
+	for size := 1; size <= (len(s)-i)/2; size++ {
+		n := size
+		for i+n < len(s) && s[i+n] == s[i+n-size] {
+			n++
+		}
+		//it only counts as a repeat once the group has appeared twice, or a single character three times
+		if n >= 2*size && n >= minMatch && n > best {
+			best = n
+		}
+	}
+	return best
+}
+
+// isYear reports whether a year from the 1900s or 2000s starts at i
+func isYear(s []rune, i int) bool {
+	if len(s)-i < 4 {
+		return false
+	}
+	for _, r := range s[i : i+4] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	century := string(s[i : i+2])
+	return century == "19" || century == "20"
+}
+
+// userInputTokens splits inputs such as names and email addresses into lower case tokens
+func userInputTokens(inputs []string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, input := range inputs {
+		input = strings.ToLower(input)
+		local, _, _ := strings.Cut(input, "@")
+		for _, t := range append([]string{input, local}, strings.FieldsFunc(input, isSeparator)...) {
+			if len(t) >= minMatch {
+				tokens[t] = true
+			}
+		}
+	}
+	return tokens
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// poolSize returns how many characters each character of password could be, judging by the kinds it uses
+func poolSize(password []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	if size == 0 {
+		size = 1
+	}
+	return size
+}
+
+func hasUpper(runes []rune) bool {
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
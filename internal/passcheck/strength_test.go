@@ -0,0 +1,47 @@
+package passcheck
+
+import "testing"
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name        string
+		password    string
+		userInputs  []string
+		wantScore   int
+		wantWarning string
+	}{
+		{"Common password", "password", nil, ScoreTooGuessable, WarningCommonWord},
+		{"Substitutions", "P@ssw0rd", nil, ScoreTooGuessable, WarningCommonWord},
+		{"Team and a digit", "yankees1", nil, ScoreTooGuessable, WarningCommonWord},
+		{"Another team and a digit", "chelsea1", nil, ScoreTooGuessable, WarningCommonWord},
+		{"Word and digits", "monkey123", nil, ScoreVeryGuessable, WarningCommonWord},
+		{"Name and year", "jessica1990", nil, ScoreVeryGuessable, WarningCommonWord},
+		{"Keyboard row", "qwertyuiop", nil, ScoreTooGuessable, WarningCommonWord},
+		{"Sequence", "lmnopqrstu", nil, ScoreTooGuessable, WarningSequence},
+		{"Repeat", "zzzzzzzzzz", nil, ScoreTooGuessable, WarningRepeat},
+		{"User's name", "aliceliddell", []string{"Alice Liddell", "alice@example.com"}, ScoreTooGuessable, WarningUserInput},
+		{"Random", "xK9#mQ2$vL7p", nil, ScoreVeryUnguessable, ""},
+		{"Passphrase", "correct horse battery staple", nil, ScoreVeryUnguessable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Estimate(tt.password, tt.userInputs...)
+			if got.Score != tt.wantScore {
+				t.Errorf("got score %d (%.1f bits), want %d", got.Score, got.Bits, tt.wantScore)
+			}
+			if got.Warning != tt.wantWarning {
+				t.Errorf("got warning %q, want %q", got.Warning, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestEstimateWeakerThanRequired(t *testing.T) {
+	//passwords from breaches that look complex enough, new passwords need ScoreSomewhatGuessable
+	for _, password := range []string{"yankees1", "chelsea1", "iloveyou2", "summer2023!", "Password1!", "letmein!", "hunter2"} {
+		if got := Estimate(password); got.Score >= ScoreSomewhatGuessable {
+			t.Errorf("%q: got score %d (%.1f bits), want less than %d", password, got.Score, got.Bits, ScoreSomewhatGuessable)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+// Argon2id hashes with argon2id, encoded in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>. Memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// argon2idParams are the parameters and values decoded from a hash
+type argon2idParams struct {
+	version      int
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) Verify(encoded, password string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (a Argon2id) Current(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	return p.version == argon2.Version && p.memory == a.Memory && p.time == a.Time && p.threads == a.Threads &&
+		len(p.salt) == int(a.SaltLen) && len(p.key) == int(a.KeyLen)
+}
+
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	//"", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownScheme
+	}
+
+	p := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return nil, fmt.Errorf("passhash: invalid argon2id version: %w", err)
+	}
+	if p.version != argon2.Version {
+		return nil, fmt.Errorf("passhash: unsupported argon2id version %d", p.version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, fmt.Errorf("passhash: invalid argon2id parameters: %w", err)
+	}
+
+	var err error
+	b64 := base64.RawStdEncoding
+	if p.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("passhash: invalid argon2id salt: %w", err)
+	}
+	if p.key, err = b64.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("passhash: invalid argon2id key: %w", err)
+	}
+	if p.time == 0 || p.threads == 0 || len(p.key) == 0 {
+		return nil, fmt.Errorf("passhash: invalid argon2id parameters")
+	}
+	return p, nil
+}
@@ -0,0 +1,88 @@
+// Package passhash hashes passwords into self-describing strings that record the
+// algorithm and its parameters, so hashes made with older settings can still be
+// verified and then upgraded.
+package passhash
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnknownScheme is returned for an encoded hash that no scheme of the Hasher recognises.
+var ErrUnknownScheme = errors.New("passhash: unknown hash format")
+
+// Scheme is a password hashing algorithm with particular parameters.
+type Scheme interface {
+	// Hash returns the encoded hash of password.
+	Hash(password string) (string, error)
+	// Recognizes reports whether encoded was made by this algorithm, with any parameters.
+	Recognizes(encoded string) bool
+	// Verify reports whether password matches encoded, which the scheme recognises.
+	Verify(encoded, password string) (bool, error)
+	// Current reports whether encoded was made with this scheme's parameters.
+	Current(encoded string) bool
+}
+
+// Hasher hashes new passwords with Default, and verifies hashes made by Default or
+// any of Legacy.
+type Hasher struct {
+	Default Scheme
+	Legacy  []Scheme
+	// MaxConcurrent limits how many passwords are hashed or verified at once, the rest
+	// wait their turn. Each argon2id hash needs its Memory for as long as it runs, so
+	// this bounds the memory used by a burst of logins. Zero means no limit.
+	MaxConcurrent int
+
+	semOnce sync.Once
+	sem     chan struct{}
+}
+
+// Default hashes with argon2id and still verifies the bcrypt hashes of older accounts.
+// At most 4 hashes run at once, which is 256MiB.
+var Default = &Hasher{
+	Default:       Argon2id{Time: 3, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32},
+	Legacy:        []Scheme{Bcrypt{Cost: 12}},
+	MaxConcurrent: 4,
+}
+
+// acquire waits for a free hashing slot, the returned func gives it back
+func (h *Hasher) acquire() (release func()) {
+	if h.MaxConcurrent <= 0 {
+		return func() {}
+	}
+
+	h.semOnce.Do(func() {
+		h.sem = make(chan struct{}, h.MaxConcurrent)
+	})
+	h.sem <- struct{}{}
+	return func() { <-h.sem }
+}
+
+// Hash returns the encoded hash of password using the default scheme.
+func (h *Hasher) Hash(password string) (string, error) {
+	defer h.acquire()()
+	return h.Default.Hash(password)
+}
+
+// Verify reports whether password matches encoded. When it does, rehash reports
+// whether encoded should be replaced by a new hash because it was made with a
+// legacy algorithm or outdated parameters. An empty encoded never matches.
+func (h *Hasher) Verify(encoded, password string) (match, rehash bool, err error) {
+	if encoded == "" {
+		return false, false, nil
+	}
+	defer h.acquire()()
+
+	if h.Default.Recognizes(encoded) {
+		match, err = h.Default.Verify(encoded, password)
+		return match, match && !h.Default.Current(encoded), err
+	}
+
+	for _, s := range h.Legacy {
+		if s.Recognizes(encoded) {
+			match, err = s.Verify(encoded, password)
+			return match, match, err
+		}
+	}
+	return false, false, ErrUnknownScheme
+}
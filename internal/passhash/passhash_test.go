@@ -0,0 +1,113 @@
+package passhash
+
+import "testing"
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := Default.Hash("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		password  string
+		wantMatch bool
+	}{
+		{"Right password", "correct horse battery", true},
+		{"Wrong password", "wrong horse battery", false},
+		{"Empty password", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := Default.Verify(encoded, tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match != tt.wantMatch {
+				t.Errorf("got match %t, want %t", match, tt.wantMatch)
+			}
+			if rehash {
+				t.Error("got rehash for a hash made with the current parameters")
+			}
+		})
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme Scheme
+	}{
+		{"Bcrypt cost 10", Bcrypt{Cost: 10}},
+		{"Bcrypt cost 12", Bcrypt{Cost: 12}},
+		{"Argon2id older time", Argon2id{Time: 1, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32}},
+		{"Argon2id less memory", Argon2id{Time: 3, Memory: 8 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32}},
+		{"Argon2id shorter key", Argon2id{Time: 3, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 16}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.scheme.Hash("correct horse battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			match, rehash, err := Default.Verify(encoded, "correct horse battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !match || !rehash {
+				t.Errorf("got match %t and rehash %t, want both", match, rehash)
+			}
+
+			//only a matching password is worth rehashing, it's the one that gets hashed again
+			match, rehash, err = Default.Verify(encoded, "wrong horse battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match || rehash {
+				t.Errorf("wrong password: got match %t and rehash %t, want neither", match, rehash)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"Prefix only", "$argon2id$"},
+		{"Missing key", "$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"Bad version", "$argon2id$v=18$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"Bad parameters", "$argon2id$v=19$m=lots$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"Zero time", "$argon2id$v=19$m=65536,t=0,p=4$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"Bad salt", "$argon2id$v=19$m=65536,t=3,p=4$!!!$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"Empty key", "$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA$"},
+		{"Unknown scheme", "$1$salt$hash"},
+		{"Plain text", "correct horse battery"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := Default.Verify(tt.encoded, "correct horse battery")
+			if err == nil {
+				t.Error("got no error")
+			}
+			if match || rehash {
+				t.Errorf("got match %t and rehash %t, want neither", match, rehash)
+			}
+		})
+	}
+}
+
+func TestVerifyEmptyHash(t *testing.T) {
+	//accounts created by single sign-on have no password, and nothing must log in to them with one
+	for _, password := range []string{"", "correct horse battery"} {
+		match, rehash, err := Default.Verify("", password)
+		if match || rehash || err != nil {
+			t.Errorf("%q: got match %t, rehash %t and error %v, want no match", password, match, rehash, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+// MemoryLimiter keeps buckets in memory, so limits only apply per process.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	//the clock, time.Now except in tests
+	now func() time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return newMemoryLimiter(time.Now)
+}
+
+func newMemoryLimiter(now func() time.Time) *MemoryLimiter {
+	return &MemoryLimiter{buckets: map[string]*bucket{}, lastSweep: now(), now: now}
+}
+
+func (l *MemoryLimiter) Allow(key string, rate Rate) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Requests), last: now}
+		l.buckets[key] = b
+	}
+
+	tokens, allowed, retryAfter := take(b.tokens, b.last, now, rate)
+	b.tokens, b.last, b.period = tokens, now, rate.Period
+
+	return allowed, retryAfter, nil
+}
+
+// sweep forgets buckets that have had time to refill completely, since a new
+// bucket would be in exactly the same state. It runs at most once a minute.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > b.period {
+			delete(l.buckets, key)
+		}
+	}
+}
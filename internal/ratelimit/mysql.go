@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MySQLLimiter keeps buckets in the rate_limits table, so that every instance
+// of the application shares the same limits.
+type MySQLLimiter struct {
+	DB *sql.DB
+}
+
+func (l *MySQLLimiter) Allow(key string, rate Rate) (bool, time.Duration, error) {
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	//create a full bucket if there isn't one yet, then lock the row so concurrent requests queue up behind this one
+	_, err = tx.Exec(`INSERT IGNORE INTO rate_limits (bucket_key, tokens, updated) VALUES (?, ?, UTC_TIMESTAMP(6))`,
+		key, rate.Requests)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var tokens float64
+	var last, now time.Time
+	err = tx.QueryRow(`SELECT tokens, updated, UTC_TIMESTAMP(6) FROM rate_limits WHERE bucket_key = ? FOR UPDATE`, key).
+		Scan(&tokens, &last, &now)
+	if err != nil {
+		return false, 0, err
+	}
+
+	//the database clock is used throughout so instances with skewed clocks agree
+	tokens, allowed, retryAfter := take(tokens, last, now, rate)
+
+	_, err = tx.Exec(`UPDATE rate_limits SET tokens = ?, updated = ? WHERE bucket_key = ?`, tokens, now, key)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, err
+	}
+	return allowed, retryAfter, nil
+}
+
+// DeleteIdle removes buckets that haven't been used for longer than idle,
+// which should be at least the longest Period in use.
+func (l *MySQLLimiter) DeleteIdle(idle time.Duration) error {
+	_, err := l.DB.Exec(`DELETE FROM rate_limits WHERE updated < DATE_SUB(UTC_TIMESTAMP(6), INTERVAL ? SECOND)`,
+		int(idle.Seconds()))
+	return err
+}
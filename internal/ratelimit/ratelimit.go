@@ -0,0 +1,40 @@
+// Package ratelimit implements token bucket rate limiting with in-memory and
+// MySQL backed buckets.
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Rate allows bursts of up to Requests requests, refilling the bucket
+// completely over Period, e.g. Rate{Requests: 10, Period: time.Minute}.
+type Rate struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Limiter decides whether the request identified by key may proceed. When it
+// may not, retryAfter is how long until a token becomes available.
+type Limiter interface {
+	Allow(key string, rate Rate) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// take refills a bucket holding tokens that was last updated at last, and takes
+// a token from it if one is available. It returns the new number of tokens.
+func take(tokens float64, last, now time.Time, rate Rate) (float64, bool, time.Duration) {
+	capacity := float64(rate.Requests)
+	perToken := rate.Period.Seconds() / capacity
+
+	elapsed := now.Sub(last).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(capacity, tokens+elapsed/perToken)
+	}
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	retryAfter := time.Duration((1 - tokens) * perToken * float64(time.Second))
+	return tokens, false, retryAfter
+}
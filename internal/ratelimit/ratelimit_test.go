@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	//a token every 10 seconds
+	rate := Rate{Requests: 3, Period: 30 * time.Second}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		tokens         float64
+		elapsed        time.Duration
+		wantTokens     float64
+		wantAllowed    bool
+		wantRetryAfter time.Duration
+	}{
+		{"Full", 3, 0, 2, true, 0},
+		{"Last token", 1, 0, 0, true, 0},
+		{"Empty", 0, 0, 0, false, 10 * time.Second},
+		{"Part refilled", 0, 4 * time.Second, 0.4, false, 6 * time.Second},
+		{"One refilled", 0, 10 * time.Second, 0, true, 0},
+		{"Capped at the burst", 0, time.Hour, 2, true, 0},
+		{"Clock going backwards", 0.5, -time.Minute, 0.5, false, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, allowed, retryAfter := take(tt.tokens, start, start.Add(tt.elapsed), rate)
+			if !approxEqual(tokens, tt.wantTokens) || allowed != tt.wantAllowed || retryAfter.Round(time.Millisecond) != tt.wantRetryAfter {
+				t.Errorf("got %.2f tokens, %t and retry after %s, want %.2f tokens, %t and retry after %s",
+					tokens, allowed, retryAfter, tt.wantTokens, tt.wantAllowed, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+// fakeClock is a clock for MemoryLimiter that only moves when told to
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newMemoryLimiter(clock.now)
+	rate := Rate{Requests: 3, Period: 30 * time.Second}
+
+	//the steps run in order, each one sees the buckets the previous ones left
+	steps := []struct {
+		name           string
+		advance        time.Duration
+		key            string
+		wantAllowed    bool
+		wantRetryAfter time.Duration
+	}{
+		{"Burst 1", 0, "a", true, 0},
+		{"Burst 2", 0, "a", true, 0},
+		{"Burst 3", 0, "a", true, 0},
+		{"Over the burst", 0, "a", false, 10 * time.Second},
+		{"Other key", 0, "b", true, 0},
+		{"Part refilled", 4 * time.Second, "a", false, 6 * time.Second},
+		{"Refilled", 6 * time.Second, "a", true, 0},
+		{"Empty again", 0, "a", false, 10 * time.Second},
+	}
+
+	for _, s := range steps {
+		clock.t = clock.t.Add(s.advance)
+		allowed, retryAfter, err := l.Allow(s.key, rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != s.wantAllowed || retryAfter.Round(time.Millisecond) != s.wantRetryAfter {
+			t.Errorf("%s: got %t and retry after %s, want %t and retry after %s", s.name, allowed, retryAfter, s.wantAllowed, s.wantRetryAfter)
+		}
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newMemoryLimiter(clock.now)
+	short := Rate{Requests: 1, Period: 30 * time.Second}
+	long := Rate{Requests: 1, Period: time.Hour}
+
+	l.Allow("short", short)
+	l.Allow("long", long)
+
+	//both buckets are kept until a minute has passed since the last sweep
+	clock.t = clock.t.Add(45 * time.Second)
+	l.Allow("other", short)
+	if len(l.buckets) != 3 {
+		t.Fatalf("after 45 seconds: got %d buckets, want 3", len(l.buckets))
+	}
+
+	//then the ones that have refilled completely are forgotten
+	clock.t = clock.t.Add(30 * time.Second)
+	l.Allow("other", short)
+	if _, ok := l.buckets["short"]; ok {
+		t.Error("refilled bucket wasn't swept")
+	}
+	if _, ok := l.buckets["long"]; !ok {
+		t.Error("bucket that's still refilling was swept")
+	}
+
+	//a swept bucket starts full again, like it would have been
+	allowed, _, err := l.Allow("short", short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("request with a swept bucket wasn't allowed")
+	}
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Finding is a likely credential found on a line of text.
+type Finding struct {
+	Line int
+	Kind string
+}
+
+type rule struct {
+	kind string
+	rx   *regexp.Regexp
+}
+
+// rules for well-known credential formats, checked before the entropy heuristic
+var rules = []rule{
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws.{0,20}secret.{0,20}[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{20,}\b`)},
+	{"Snippetbox API token", regexp.MustCompile(`\bsbx_[A-Za-z0-9_-]{43}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{"JSON Web Token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+}
+
+// candidates for the entropy check, long runs of characters used by base64 and hex encoded secrets
+var tokenRX = regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`)
+
+// tokens at or above this many bits of entropy per character are reported as secrets,
+// random base64 comes out at around 5 and English or identifiers well below 4
+const minEntropy = 4.5
+
+// hex has only 16 characters, so random hex can't get above 4 bits per character, and a
+// 32 character key seldom shows more than 3.5 of them
+const minHexEntropy = 3.0
+
+// Scan returns the likely credentials in content, ordered by line number.
+// Each kind is reported at most once per line.
+func Scan(content string) []Finding {
+	findings := []Finding{}
+
+	for i, line := range strings.Split(content, "\n") {
+		seen := map[string]bool{}
+		for _, r := range rules {
+			if !seen[r.kind] && r.rx.MatchString(line) {
+				seen[r.kind] = true
+				findings = append(findings, Finding{Line: i + 1, Kind: r.kind})
+			}
+		}
+
+		//only fall back to the heuristic when no specific rule matched the line
+		if len(seen) == 0 {
+			for _, token := range tokenRX.FindAllString(line, -1) {
+				if isHighEntropy(token) {
+					findings = append(findings, Finding{Line: i + 1, Kind: "high-entropy token"})
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings
+}
+
+// isHighEntropy reports whether s looks like random data rather than text
+func isHighEntropy(s string) bool {
+	var upper, lower, digit, other bool
+	hexLetters := true
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+			hexLetters = hexLetters && c <= 'F'
+		case c >= 'a' && c <= 'z':
+			lower = true
+			hexLetters = hexLetters && c <= 'f'
+		case c >= '0' && c <= '9':
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	//hex keys are all one case, so they're judged against what random hex can reach
+	if hexLetters && !other && digit && upper != lower {
+		return entropy(s) >= minHexEntropy
+	}
+
+	//random tokens mix character classes, long words and paths don't
+	if !(upper && lower && digit) {
+		return false
+	}
+	return entropy(s) >= minEntropy
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+
+	n := float64(len(s))
+	var h float64
+	for _, count := range counts {
+		p := float64(count) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA-1 secret of RFC 6238 appendix B, "12345678901234567890" base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	//the RFC's codes are eight digits, these are their last six
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeSecret(t *testing.T) {
+	//authenticator apps accept the secret in lower case, so codes must be the same
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("lower case secret: got %q, want %q", got, "287082")
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret: got no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"Current", code(step), step, true},
+		{"With a space", code(step)[:3] + " " + code(step)[3:], step, true},
+		{"Previous step", code(step - 1), step - 1, true},
+		{"Next step", code(step + 1), step + 1, true},
+		{"Two steps ago", code(step - 2), 0, false},
+		{"Two steps ahead", code(step + 2), 0, false},
+		{"Too short", code(step)[:5], 0, false},
+		{"Too long", code(step) + "0", 0, false},
+		{"Eight digits", "14050471", 0, false},
+		{"Empty", "", 0, false},
+		{"Letters", "abcdef", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(rfcSecret, tt.code, now)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("got step %d and %t, want step %d and %t", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
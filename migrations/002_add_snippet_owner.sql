@@ -0,0 +1,8 @@
+-- Record which user created each snippet. Snippets created before this
+-- migration have no owner.
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NULL;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_snippets_user_id ON snippets(user_id);
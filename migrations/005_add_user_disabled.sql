@@ -0,0 +1,2 @@
+-- Disabled users can't log in, and their sessions and API tokens stop working.
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
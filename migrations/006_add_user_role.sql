@@ -0,0 +1,3 @@
+-- Roles are "user", "moderator" or "admin". Promote the first admin with:
+--   web admin set-role -email you@example.com -role admin
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
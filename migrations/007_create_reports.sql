@@ -0,0 +1,22 @@
+-- Hidden snippets are only visible to their owners.
+ALTER TABLE snippets ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Abuse reports waiting for, or handled by, a moderator.
+CREATE TABLE reports (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    reporter_id INTEGER NULL,
+    reporter_ip VARCHAR(45) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    comment TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    created DATETIME NOT NULL,
+    resolved_by INTEGER NULL,
+    resolved DATETIME NULL,
+    CONSTRAINT fk_reports_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    CONSTRAINT fk_reports_reporter FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE SET NULL,
+    CONSTRAINT fk_reports_resolved_by FOREIGN KEY (resolved_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_reports_status ON reports(status, created);
+CREATE INDEX idx_reports_reporter_ip ON reports(reporter_ip, created);
@@ -0,0 +1,2 @@
+-- Set when the author published a snippet despite the secret scanner's warning.
+ALTER TABLE snippets ADD COLUMN secrets_acknowledged DATETIME NULL;
@@ -0,0 +1,13 @@
+-- Consecutive failed logins per account. Once there are enough of them the
+-- account is locked until locked_until, see models/lockout.go.
+ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN last_failed_login DATETIME;
+ALTER TABLE users ADD COLUMN locked_until DATETIME;
+
+-- The same for client IP addresses, so one address can't work through many accounts.
+CREATE TABLE login_failures (
+    ip VARCHAR(45) NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL,
+    locked_until DATETIME
+);
@@ -0,0 +1,8 @@
+-- Password reset tokens sent by email. Only a SHA-256 hash of each token is
+-- stored, and a token is deleted as soon as it's used.
+CREATE TABLE password_resets (
+    token_hash CHAR(64) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT fk_password_resets_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
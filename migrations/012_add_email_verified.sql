@@ -0,0 +1,4 @@
+-- New accounts have to verify their email address. Existing accounts are
+-- treated as verified.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET email_verified = TRUE;
@@ -0,0 +1,12 @@
+-- TOTP two-factor authentication. totp_last_step is the time step of the last
+-- code used, so a code can't be replayed.
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Single use recovery codes for when the authenticator is lost, stored as SHA-256 hashes.
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
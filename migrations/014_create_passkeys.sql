@@ -0,0 +1,15 @@
+-- WebAuthn credentials (passkeys). credential holds the JSON encoded credential
+-- as returned by the webauthn library, sign_count is kept separately so it can
+-- be checked and updated atomically.
+CREATE TABLE passkeys (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential_id VARBINARY(1023) NOT NULL,
+    credential BLOB NOT NULL,
+    sign_count INTEGER UNSIGNED NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    CONSTRAINT fk_passkeys_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT passkeys_uc_credential_id UNIQUE (credential_id)
+);
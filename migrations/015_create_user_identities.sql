@@ -0,0 +1,12 @@
+-- Accounts at an OpenID Connect provider, identified by the issuer and the
+-- subject (sub claim), linked to local users. Users created by single sign-on
+-- have an empty hashed_password, so they can't log in with a password until
+-- they reset it.
+CREATE TABLE user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+-- Logged-in sessions, so users can see where they're logged in and log out
+-- remotely. id is a random value kept in the scs session data, since the scs
+-- token changes whenever it's renewed. Deleting a row logs that session out.
+CREATE TABLE user_sessions (
+    id CHAR(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    CONSTRAINT fk_user_sessions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
+CREATE INDEX idx_user_sessions_expires ON user_sessions(expires);
@@ -0,0 +1,4 @@
+-- Password hashes now record their algorithm and parameters, and argon2id
+-- hashes don't fit in the 60 characters of a bcrypt hash. Existing bcrypt
+-- hashes are kept and upgraded to argon2id when their owner next logs in.
+ALTER TABLE users MODIFY hashed_password VARCHAR(255) NOT NULL;
@@ -0,0 +1,8 @@
+-- Keys generated by the server on first start when none is given on the command
+-- line, so that every instance signs links with the same key and links keep
+-- working across restarts.
+CREATE TABLE signing_keys (
+    name VARCHAR(50) NOT NULL PRIMARY KEY,
+    value VARBINARY(64) NOT NULL,
+    created DATETIME NOT NULL
+);
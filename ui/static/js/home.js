@@ -0,0 +1,54 @@
+// Keep the list of latest snippets on the home page up to date using the
+// server-sent events published at /events.
+(function () {
+	if (!window.EventSource) {
+		return;
+	}
+
+	var maxRows = 10;
+
+	function latestTable() {
+		var table = document.getElementById("latest-snippets");
+		if (table) {
+			return table;
+		}
+
+		// The first snippet replaces the "nothing to see here" message.
+		table = document.createElement("table");
+		table.id = "latest-snippets";
+		var header = table.insertRow();
+		["Title", "Created", "ID"].forEach(function (name) {
+			var th = document.createElement("th");
+			th.textContent = name;
+			header.appendChild(th);
+		});
+
+		var empty = document.getElementById("no-snippets");
+		empty.parentNode.replaceChild(table, empty);
+		return table;
+	}
+
+	function prepend(snippet) {
+		var table = latestTable();
+		var rows = table.tBodies.length ? table.tBodies[0] : table;
+
+		// Row 0 is the header, so new snippets go in at index 1.
+		var row = rows.insertRow(1);
+
+		var link = document.createElement("a");
+		link.href = "/snippet/view/" + snippet.id;
+		link.textContent = snippet.title;
+		row.insertCell().appendChild(link);
+		row.insertCell().textContent = snippet.createdHuman;
+		row.insertCell().textContent = "#" + snippet.id;
+
+		while (rows.rows.length > maxRows + 1) {
+			rows.deleteRow(rows.rows.length - 1);
+		}
+	}
+
+	var source = new EventSource("/events");
+	source.addEventListener("snippet", function (e) {
+		prepend(JSON.parse(e.data));
+	});
+})();
@@ -0,0 +1,120 @@
+// Registers passkeys on /user/passkeys and logs in with them on /user/login,
+// passing WebAuthn options and responses between the server and the browser.
+(function () {
+	if (!window.PublicKeyCredential) {
+		return;
+	}
+
+	// Binary fields travel as base64url strings in JSON.
+	function decode(value) {
+		var base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+		return Uint8Array.from(atob(base64), function (c) {
+			return c.charCodeAt(0);
+		});
+	}
+
+	function encode(buffer) {
+		var binary = String.fromCharCode.apply(null, new Uint8Array(buffer));
+		return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	}
+
+	// post sends a JSON body with the CSRF token in a header. The finishing
+	// requests answer with a redirect, which is followed by navigating there.
+	function post(url, csrfToken, body) {
+		return fetch(url, {
+			method: "POST",
+			headers: {"X-CSRF-Token": csrfToken, "Content-Type": "application/json"},
+			body: body ? JSON.stringify(body) : null,
+			credentials: "same-origin"
+		}).then(function (response) {
+			if (response.redirected) {
+				window.location = response.url;
+				return null;
+			}
+			return response.json().then(function (data) {
+				if (!response.ok) {
+					throw new Error(data.error);
+				}
+				return data;
+			});
+		});
+	}
+
+	function showError(err) {
+		var el = document.getElementById("passkey-error");
+		el.textContent = err.message || "Something went wrong, please try again.";
+		el.hidden = false;
+	}
+
+	function register(button) {
+		var csrfToken = button.dataset.csrfToken;
+		var name = document.getElementById("passkey-name").value;
+		var password = document.getElementById("passkey-password");
+
+		// Adding a passkey is confirmed with the current password, users
+		// without one have no field and confirm by having just logged in.
+		post("/user/passkeys/register/begin", csrfToken, {password: password ? password.value : ""}).then(function (options) {
+			var publicKey = options.publicKey;
+			publicKey.challenge = decode(publicKey.challenge);
+			publicKey.user.id = decode(publicKey.user.id);
+			(publicKey.excludeCredentials || []).forEach(function (c) {
+				c.id = decode(c.id);
+			});
+			return navigator.credentials.create({publicKey: publicKey});
+		}).then(function (credential) {
+			var response = credential.response;
+			return post("/user/passkeys/register/finish?name=" + encodeURIComponent(name), csrfToken, {
+				id: credential.id,
+				rawId: encode(credential.rawId),
+				type: credential.type,
+				response: {
+					clientDataJSON: encode(response.clientDataJSON),
+					attestationObject: encode(response.attestationObject),
+					transports: response.getTransports ? response.getTransports() : []
+				}
+			});
+		}).catch(showError);
+	}
+
+	function login(button) {
+		var csrfToken = button.dataset.csrfToken;
+
+		post("/user/login/passkey/begin", csrfToken).then(function (options) {
+			var publicKey = options.publicKey;
+			publicKey.challenge = decode(publicKey.challenge);
+			(publicKey.allowCredentials || []).forEach(function (c) {
+				c.id = decode(c.id);
+			});
+			return navigator.credentials.get({publicKey: publicKey});
+		}).then(function (credential) {
+			var response = credential.response;
+			return post("/user/login/passkey/finish", csrfToken, {
+				id: credential.id,
+				rawId: encode(credential.rawId),
+				type: credential.type,
+				response: {
+					clientDataJSON: encode(response.clientDataJSON),
+					authenticatorData: encode(response.authenticatorData),
+					signature: encode(response.signature),
+					userHandle: response.userHandle ? encode(response.userHandle) : null
+				}
+			});
+		}).catch(showError);
+	}
+
+	var registerButton = document.getElementById("passkey-register");
+	if (registerButton) {
+		registerButton.hidden = false;
+		registerButton.addEventListener("click", function () {
+			register(registerButton);
+		});
+	}
+
+	var loginButton = document.getElementById("passkey-login");
+	if (loginButton) {
+		loginButton.hidden = false;
+		loginButton.addEventListener("click", function () {
+			login(loginButton);
+		});
+	}
+})();